@@ -0,0 +1,107 @@
+package maglev
+
+import "errors"
+
+// Builder configures and builds a Maglev. The zero value is ready to use; the number of
+// partitions must be set, while the hashers default to DefaultHashers.
+//
+//	m, err := new(maglev.Builder).Nodes("a", "b").Partitions(65537).Build()
+type Builder struct {
+	nodes         []string
+	weights       map[string]uint64
+	numPartitions uint64
+	h1, h2        Hasher
+	hashersSet    bool
+	opts          []Option
+}
+
+// NewBuilder returns a new Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Nodes adds nodes with weight 1.
+func (b *Builder) Nodes(nodes ...string) *Builder {
+	b.nodes = append(b.nodes, nodes...)
+	return b
+}
+
+// Weights adds weighted nodes. A node that was also passed to Nodes gets the given weight.
+func (b *Builder) Weights(weights map[string]uint64) *Builder {
+	if b.weights == nil {
+		b.weights = make(map[string]uint64, len(weights))
+	}
+	for node, weight := range weights {
+		b.weights[node] = weight
+	}
+	return b
+}
+
+// Partitions sets the number of partitions, which must be prime and therefore at least 2.
+func (b *Builder) Partitions(numPartitions uint64) *Builder {
+	b.numPartitions = numPartitions
+	return b
+}
+
+// Hashers sets the hashers used to generate permutations.
+func (b *Builder) Hashers(h1, h2 Hasher) *Builder {
+	b.h1, b.h2 = h1, h2
+	b.hashersSet = true
+	return b
+}
+
+// KeyHasher sets the hasher used to hash keys, see WithKeyHasher.
+func (b *Builder) KeyHasher(h Hasher) *Builder {
+	return b.Options(WithKeyHasher(h))
+}
+
+// Strict enables validation of the hashers, see WithStrictValidation.
+func (b *Builder) Strict() *Builder {
+	return b.Options(WithStrictValidation())
+}
+
+// Deferred defers rebuilding the lookup table after Add and Remove until Rebuild is called, see
+// WithDeferredRebuild.
+func (b *Builder) Deferred() *Builder {
+	return b.Options(WithDeferredRebuild())
+}
+
+// AutoPrime rounds the number of partitions up to the next prime, see WithAutoPrime.
+func (b *Builder) AutoPrime() *Builder {
+	return b.Options(WithAutoPrime())
+}
+
+// Options adds arbitrary options.
+func (b *Builder) Options(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build returns a new Maglev with the configuration of the Builder.
+func (b *Builder) Build() (*Maglev, error) {
+	h1, h2 := b.h1, b.h2
+	if !b.hashersSet {
+		h1, h2 = DefaultHashers()
+	}
+
+	nodes := make([]string, 0, len(b.nodes)+len(b.weights))
+	nodes = append(nodes, b.nodes...)
+	var weights map[string]uint64
+	if len(b.weights) > 0 {
+		unweighted := make(map[string]struct{}, len(b.nodes))
+		for _, node := range b.nodes {
+			unweighted[node] = struct{}{}
+		}
+		weights = make(map[string]uint64, len(b.weights))
+		for node, weight := range b.weights {
+			if weight == 0 {
+				return nil, errors.New("node weight must be positive")
+			}
+			weights[node] = weight
+			if _, ok := unweighted[node]; !ok {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return newMaglev(nodes, weights, b.numPartitions, h1, h2, b.opts)
+}
@@ -0,0 +1,57 @@
+package maglev
+
+import "testing"
+
+func TestBuilderMinimal(t *testing.T) {
+	m, err := NewBuilder().Nodes("a", "b").Partitions(13).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := DefaultHashers()
+	want, err := NewMaglev([]string{"a", "b"}, 13, h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Error("minimal build differs from NewMaglev with the default hashers")
+	}
+	if _, err := NewBuilder().Nodes("a").Build(); err == nil {
+		t.Error("Build without partitions succeeded")
+	}
+}
+
+func TestBuilderFull(t *testing.T) {
+	m, err := NewBuilder().
+		Nodes("a", "b").
+		Weights(map[string]uint64{"b": 2, "c": 3}).
+		Partitions(100).
+		AutoPrime().
+		Hashers(FNVHasher{}, XXHasher{}).
+		KeyHasher(FNVHasher{}).
+		Strict().
+		Deferred().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewWeightedMaglev(map[string]uint64{"a": 1, "b": 2, "c": 3}, 101, FNVHasher{}, XXHasher{},
+		WithKeyHasher(FNVHasher{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Error("full build differs from the equivalent NewWeightedMaglev")
+	}
+	if got, want := m.LookupString("key"), want.LookupString("key"); got != want {
+		t.Errorf("LookupString(\"key\") = %q, want %q", got, want)
+	}
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Stale() {
+		t.Error("Add did not defer the rebuild")
+	}
+	if _, err := NewBuilder().Partitions(13).Hashers(XXHasher{}, XXHasher{}).Strict().Nodes("a").Build(); err == nil {
+		t.Error("strict build with identical hashers succeeded")
+	}
+}
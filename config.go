@@ -0,0 +1,63 @@
+package maglev
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// RingConfig describes the membership of a Maglev: its nodes and number of partitions. The
+// lookup table is not part of the configuration since it is derived from it.
+type RingConfig struct {
+	Nodes         []string
+	NumPartitions uint64
+}
+
+// ringConfigJSON is the JSON representation of RingConfig.
+type ringConfigJSON struct {
+	Nodes         []string `json:"nodes"`
+	NumPartitions uint64   `json:"num_partitions"`
+}
+
+// MarshalJSON implements json.Marshaler. Nodes are written sorted and without duplicates, so
+// that equivalent configurations have identical encodings.
+func (c RingConfig) MarshalJSON() ([]byte, error) {
+	nodes := append([]string{}, c.Nodes...)
+	sort.Strings(nodes)
+	return json.Marshal(ringConfigJSON{
+		Nodes:         dedupSorted(nodes),
+		NumPartitions: c.NumPartitions,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Unknown fields and a missing number of
+// partitions are rejected.
+func (c *RingConfig) UnmarshalJSON(data []byte) error {
+	var v ringConfigJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if v.NumPartitions == 0 {
+		return errors.New("ring config: num_partitions is required")
+	}
+	c.Nodes = v.Nodes
+	c.NumPartitions = v.NumPartitions
+	return nil
+}
+
+// Config returns the configuration of m.
+func (m *Maglev) Config() RingConfig {
+	s := m.load()
+	return RingConfig{
+		Nodes:         append([]string{}, s.nodes...),
+		NumPartitions: s.numPartitions,
+	}
+}
+
+// FromConfig initializes a Maglev hasher from a configuration.
+func FromConfig(cfg RingConfig, h1, h2 Hasher, opts ...Option) (*Maglev, error) {
+	return NewMaglev(cfg.Nodes, cfg.NumPartitions, h1, h2, opts...)
+}
@@ -0,0 +1,41 @@
+package maglev
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRingConfigJSON(t *testing.T) {
+	cfg := RingConfig{Nodes: []string{"c", "a", "b", "a"}, NumPartitions: 101}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"nodes":["a","b","c"],"num_partitions":101}`; string(data) != want {
+		t.Errorf("json.Marshal = %s, want %s", data, want)
+	}
+	var decoded RingConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	m, err := FromConfig(decoded, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Error("ring built from the decoded config routes differently")
+	}
+	if got, err := json.Marshal(m.Config()); err != nil || string(got) != string(data) {
+		t.Errorf("json.Marshal(Config()) = %s, %v, want %s", got, err, data)
+	}
+
+	for _, data := range []string{`{"nodes":["a"]}`, `{"nodes":["a"],"num_partitions":13,"seed":1}`, `[]`} {
+		if err := json.Unmarshal([]byte(data), &decoded); err == nil {
+			t.Errorf("json.Unmarshal(%s) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package maglev
+
+import (
+	"net"
+	"net/http"
+)
+
+// RequestKey extracts the routing key from an HTTP request.
+type RequestKey func(r *http.Request) string
+
+// ClientIPKey uses the IP address of the client as the routing key, so all requests from
+// the same client are routed to the same backend.
+func ClientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// PathKey uses the URL path of the request as the routing key.
+func PathKey(r *http.Request) string {
+	return r.URL.Path
+}
+
+// HeaderKey returns a RequestKey that uses the value of the named header as the routing key.
+func HeaderKey(name string) RequestKey {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Director picks backends for HTTP requests with a Maglev.
+type Director struct {
+	m   *Maglev
+	key RequestKey
+}
+
+// NewDirector returns a Director that routes requests with m, deriving the key of each
+// request with key. A nil key defaults to ClientIPKey.
+func NewDirector(m *Maglev, key RequestKey) *Director {
+	if key == nil {
+		key = ClientIPKey
+	}
+	return &Director{m: m, key: key}
+}
+
+// Pick returns the backend for the request. The key derived from the request is hashed with
+// the key hasher of the Maglev, as in LookupString.
+func (d *Director) Pick(r *http.Request) string {
+	return d.m.LookupString(d.key(r))
+}
@@ -0,0 +1,53 @@
+package maglev
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDirector(t *testing.T) {
+	m, err := NewMaglev([]string{"backend-1", "backend-2", "backend-3"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []RequestKey{nil, ClientIPKey} {
+		d := NewDirector(m, key)
+		for _, addr := range []string{"10.0.0.1", "10.0.0.2", "192.168.1.7", "[::1]"} {
+			want := d.Pick(requestFrom(addr + ":1234"))
+			if want == "" {
+				t.Fatalf("Pick returned no backend for %s", addr)
+			}
+			// other requests of the same client go to the same backend, whatever the port
+			for _, port := range []string{":1", ":80", ":65535"} {
+				if got := d.Pick(requestFrom(addr + port)); got != want {
+					t.Errorf("Pick for %s%s = %q, want %q", addr, port, got, want)
+				}
+			}
+			if got := m.LookupString(ClientIPKey(requestFrom(addr + ":1234"))); got != want {
+				t.Errorf("Pick for %s = %q, but LookupString returns %q", addr, want, got)
+			}
+		}
+	}
+}
+
+func TestDirectorKeys(t *testing.T) {
+	r := httptest.NewRequest("GET", "/users/42", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Tenant", "acme")
+	if got := ClientIPKey(r); got != "10.0.0.1" {
+		t.Errorf("ClientIPKey = %q, want %q", got, "10.0.0.1")
+	}
+	if got := PathKey(r); got != "/users/42" {
+		t.Errorf("PathKey = %q, want %q", got, "/users/42")
+	}
+	if got := HeaderKey("X-Tenant")(r); got != "acme" {
+		t.Errorf("HeaderKey = %q, want %q", got, "acme")
+	}
+}
+
+func requestFrom(addr string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = addr
+	return r
+}
@@ -0,0 +1,59 @@
+package maglev
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportDOT writes the ownership of the partitions in Graphviz DOT format, e.g. for incident
+// reviews: every node is a vertex labeled with the number of partitions it owns and their ids,
+// with consecutive ids collapsed into ranges such as 3-7. It is a debugging aid that is not
+// meant to be called on a hot path.
+func (m *Maglev) ExportDOT(w io.Writer) error {
+	s := m.load()
+	owned := make([][]int, len(s.lookupNodes))
+	for partition, i := range s.lookup {
+		owned[i] = append(owned[i], partition)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph maglev {\n")
+	fmt.Fprintf(&buf, "\tlabel=\"%d nodes, %d partitions\";\n", len(s.lookupNodes), s.numPartitions)
+	buf.WriteString("\tnode [shape=box];\n")
+	for i, node := range s.lookupNodes {
+		fmt.Fprintf(&buf, "\t\"%s\" [label=\"%s\\n%d partitions: %s\"];\n",
+			dotEscape(node), dotEscape(node), len(owned[i]), partitionRanges(owned[i]))
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dotEscape escapes s for use in a quoted DOT string.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// partitionRanges formats the sorted partition ids, collapsing consecutive ids into ranges,
+// e.g. "0-2, 5, 7-8".
+func partitionRanges(partitions []int) string {
+	var b strings.Builder
+	for i := 0; i < len(partitions); {
+		j := i
+		for j+1 < len(partitions) && partitions[j+1] == partitions[j]+1 {
+			j++
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if i == j {
+			fmt.Fprintf(&b, "%d", partitions[i])
+		} else {
+			fmt.Fprintf(&b, "%d-%d", partitions[i], partitions[j])
+		}
+		i = j + 1
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package maglev
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportDOT(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.ExportDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph maglev {
+	label="3 nodes, 13 partitions";
+	node [shape=box];
+	"a" [label="a\n5 partitions: 2-4, 9, 12"];
+	"b" [label="b\n4 partitions: 1, 7-8, 10"];
+	"c" [label="c\n4 partitions: 0, 5-6, 11"];
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("ExportDOT wrote\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := m.Add(`d"e`); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := m.ExportDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"d\"e" [label="d\"e\n`) {
+		t.Errorf("ExportDOT did not escape a node name:\n%s", buf.String())
+	}
+
+	if err := m.ExportDOT(failingWriter{}); err == nil {
+		t.Error("ExportDOT did not return the error of the writer")
+	}
+}
+
+func TestPartitionRanges(t *testing.T) {
+	tests := []struct {
+		partitions []int
+		want       string
+	}{
+		{nil, ""},
+		{[]int{3}, "3"},
+		{[]int{0, 1, 2}, "0-2"},
+		{[]int{0, 1, 2, 5, 7, 8}, "0-2, 5, 7-8"},
+	}
+	for _, tt := range tests {
+		if got := partitionRanges(tt.partitions); got != tt.want {
+			t.Errorf("partitionRanges(%v) = %q, want %q", tt.partitions, got, tt.want)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
@@ -0,0 +1,83 @@
+package maglev
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DrainNode marks the node as draining: LookupDrainAware stops routing keys to it, while Lookup
+// and the lookup table are unchanged, so keys already served by the node keep resolving to it
+// until its backend has migrated them. Draining is undone with UndrainNode and ends when the
+// node is removed. Returns an error if the node is not in Maglev.
+func (m *Maglev) DrainNode(node string) error {
+	return m.modify(func(s *state) error {
+		if !s.contains(node) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, node)
+		}
+		if s.h1 == nil {
+			return errNoHashers
+		}
+		if _, ok := s.draining[node]; ok {
+			return errUnchanged
+		}
+		s.draining = s.copyDraining()
+		s.draining[node] = struct{}{}
+		return nil
+	})
+}
+
+// UndrainNode makes LookupDrainAware route keys to the node again. Undraining a node that is
+// not draining has no effect. Returns an error if the node is not in Maglev.
+func (m *Maglev) UndrainNode(node string) error {
+	return m.modify(func(s *state) error {
+		if !s.contains(node) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, node)
+		}
+		if _, ok := s.draining[node]; !ok {
+			return errUnchanged
+		}
+		s.draining = s.copyDraining()
+		delete(s.draining, node)
+		return nil
+	})
+}
+
+// Draining returns the sorted nodes that are draining, see DrainNode.
+func (m *Maglev) Draining() []string {
+	s := m.load()
+	nodes := make([]string, 0, len(s.draining))
+	for node := range s.draining {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// LookupDrainAware returns the node the key belongs to, unless that node is draining, in which
+// case it returns the first node in the key's failover order as returned by LookupN that is not
+// draining. Keys of nodes that are not draining are therefore never moved. If all nodes are
+// draining, it returns the node the key belongs to.
+func (m *Maglev) LookupDrainAware(key uint64) string {
+	s := m.load()
+	s.observeLookups(1)
+	partition := uint64(s.partitionID(key))
+	owner := s.owner(partition)
+	if _, ok := s.draining[owner]; !ok {
+		return owner
+	}
+	for _, node := range s.preferences(partition) {
+		if _, ok := s.draining[node]; !ok {
+			return node
+		}
+	}
+	return owner
+}
+
+// copyDraining returns a copy of the draining nodes that can be modified.
+func (s *state) copyDraining() map[string]struct{} {
+	draining := make(map[string]struct{}, len(s.draining)+1)
+	for node := range s.draining {
+		draining[node] = struct{}{}
+	}
+	return draining
+}
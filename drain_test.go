@@ -0,0 +1,72 @@
+package maglev
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDrainNode(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.LookupTable()
+	if err := m.DrainNode("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DrainNode("b"); err != nil {
+		t.Errorf("draining a draining node = %v", err)
+	}
+	if got := m.Draining(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Draining() = %v, want [b]", got)
+	}
+	if !reflect.DeepEqual(m.LookupTable(), before) {
+		t.Error("DrainNode changed the lookup table")
+	}
+	for key := uint64(0); key < 10000; key++ {
+		owner, got := m.Lookup(key), m.LookupDrainAware(key)
+		switch {
+		case owner != "b" && got != owner:
+			t.Fatalf("key %d of %q moved to %q while b is draining", key, owner, got)
+		case owner == "b" && got != m.LookupN(key, 2)[1]:
+			t.Fatalf("key %d of b went to %q, want its next preference %q", key, got, m.LookupN(key, 2)[1])
+		}
+	}
+
+	if err := m.UndrainNode("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UndrainNode("b"); err != nil {
+		t.Errorf("undraining a node that is not draining = %v", err)
+	}
+	if got := m.Draining(); len(got) != 0 {
+		t.Errorf("Draining() = %v after UndrainNode", got)
+	}
+	for key := uint64(0); key < 10000; key++ {
+		if got, want := m.LookupDrainAware(key), m.Lookup(key); got != want {
+			t.Fatalf("LookupDrainAware(%d) = %q after UndrainNode, want %q", key, got, want)
+		}
+	}
+
+	for _, node := range m.Nodes() {
+		if err := m.DrainNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := m.LookupDrainAware(1), m.Lookup(1); got != want {
+		t.Errorf("LookupDrainAware(1) = %q with all nodes draining, want the owner %q", got, want)
+	}
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Draining(); !reflect.DeepEqual(got, []string{"b", "c", "d"}) {
+		t.Errorf("Draining() = %v after removing a, want [b c d]", got)
+	}
+	if err := m.DrainNode("a"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("draining an unknown node = %v, want ErrNodeNotFound", err)
+	}
+	if err := m.UndrainNode("a"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("undraining an unknown node = %v, want ErrNodeNotFound", err)
+	}
+}
@@ -0,0 +1,261 @@
+package maglev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// encodingVersion is the first byte of the binary encoding of a Maglev.
+const encodingVersion = 1
+
+var errMalformed = errors.New("malformed maglev encoding")
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding contains the nodes with
+// their weights, the number of partitions and the lookup table, but not the hashers.
+// A Maglev with pending changes that have not been applied with Rebuild cannot be marshaled.
+func (m *Maglev) MarshalBinary() ([]byte, error) {
+	s := m.load()
+	if s.stale {
+		return nil, ErrStale
+	}
+
+	buf := []byte{encodingVersion}
+	buf = appendUvarint(buf, s.numPartitions)
+	buf = appendUvarint(buf, uint64(len(s.nodes)))
+	for _, node := range s.nodes {
+		buf = appendUvarint(buf, uint64(len(node)))
+		buf = append(buf, node...)
+		buf = appendUvarint(buf, s.weight(node))
+	}
+	for _, i := range s.lookup {
+		buf = appendUvarint(buf, uint64(i))
+	}
+	return buf, nil
+}
+
+// Fingerprint returns a 64-bit FNV-1a hash of the number of partitions, the nodes and the lookup
+// table, e.g. for a control plane and its data planes to confirm that they route keys alike
+// without exchanging lookup tables. It only depends on the ring, not on the process, so equal
+// rings have equal fingerprints, and a change to the nodes or the lookup table changes it
+// except for hash collisions. Weights and hashers are not part of it, only their effect on the
+// lookup table.
+func (m *Maglev) Fingerprint() uint64 {
+	s := m.load()
+	h := fnv.New64a()
+	var buf []byte
+	write := func(v uint64) {
+		buf = appendUvarint(buf[:0], v)
+		h.Write(buf)
+	}
+	writeNodes := func(nodes []string) {
+		write(uint64(len(nodes)))
+		for _, node := range nodes {
+			write(uint64(len(node)))
+			h.Write([]byte(node))
+		}
+	}
+	write(s.numPartitions)
+	writeNodes(s.nodes)
+	// the lookup table of a stale Maglev refers to the nodes it was populated from
+	writeNodes(s.lookupNodes)
+	buf = buf[:0]
+	for _, i := range s.lookup {
+		buf = appendUvarint(buf, uint64(i))
+	}
+	h.Write(buf)
+	return h.Sum64()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It restores the nodes, the number
+// of partitions and the lookup table, keeping the hashers of m. The lookup table is used as
+// is; permutations are only generated once the nodes are changed. Data that does not describe
+// a valid ring, e.g. with more nodes than partitions, is rejected like NewFromLookup does.
+func (m *Maglev) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != encodingVersion {
+		return errors.New("unsupported maglev encoding version")
+	}
+	d := decoder{data: data[1:]}
+	numPartitions := d.uvarint()
+	numNodes := d.uvarint()
+	if d.err != nil || numNodes > uint64(len(d.data)) {
+		return errMalformed
+	}
+	if err := checkPartitions(numPartitions); err != nil {
+		return err
+	}
+	nodes := make([]string, numNodes)
+	var weights map[string]uint64
+	for i := range nodes {
+		nodes[i] = d.string()
+		if w := d.uvarint(); w != 1 {
+			if w == 0 || checkWeight(w, numPartitions) != nil {
+				return errMalformed
+			}
+			if weights == nil {
+				weights = make(map[string]uint64)
+			}
+			weights[nodes[i]] = w
+		}
+		if i > 0 && !m.nodeLess(nodes[i-1], nodes[i]) {
+			return errMalformed
+		}
+	}
+	var lookup []int32
+	if numNodes > 0 {
+		if numPartitions > uint64(len(d.data)) {
+			return errMalformed
+		}
+		lookup = make([]int32, numPartitions)
+		for i := range lookup {
+			idx := d.uvarint()
+			if idx >= numNodes {
+				return errMalformed
+			}
+			lookup[i] = int32(idx)
+		}
+	}
+	if d.err != nil || len(d.data) != 0 {
+		return errMalformed
+	}
+
+	s := &state{
+		config:        &m.config,
+		nodes:         nodes,
+		members:       membersOf(nodes),
+		weights:       weights,
+		numPartitions: numPartitions,
+		lookup:        lookup,
+		lookupNodes:   nodes,
+	}
+	// e.g. more nodes than partitions, or a node without partitions
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old := m.state.Load(); old != nil {
+		s.generation = old.generation + 1
+	}
+	m.store(s)
+	return nil
+}
+
+// LoadMaglev restores a Maglev from data produced by MarshalBinary. The hashers must be the
+// same as the ones the encoded Maglev was created with.
+func LoadMaglev(data []byte, h1, h2 Hasher, opts ...Option) (*Maglev, error) {
+	if h1 == nil || h2 == nil {
+		return nil, errNilHasher
+	}
+	m := &Maglev{
+		config: config{
+			h1: h1,
+			h2: h2,
+		},
+	}
+	m.setKeyHasher(XXHasher{})
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.keyHasher == nil {
+		return nil, errNilHasher
+	}
+	if m.maxLoad != 0 && !(m.maxLoad >= 1) {
+		return nil, errMaxLoad
+	}
+	if err := m.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewFromLookup initializes a Maglev from a lookup table computed elsewhere, e.g. by a control
+// plane, without generating permutations: the element at index i of lookup is the node owning
+// the partition with id i. Returns an error if lookup does not have numPartitions elements,
+// refers to a node that is not in nodes, or leaves a node without partitions.
+//
+// The returned Maglev has no hashers, so it only supports lookups. Add, Remove, Reconcile and
+// Resize return an error, and LookupN and PreferenceList only return the owner of each
+// partition, not the failover order, so LookupHealthy returns the empty string if the owner
+// is unhealthy.
+func NewFromLookup(lookup []string, nodes []string, numPartitions uint64) (*Maglev, error) {
+	if err := checkPartitions(numPartitions); err != nil {
+		return nil, err
+	}
+	if uint64(len(lookup)) != numPartitions {
+		return nil, fmt.Errorf("lookup table has %d partitions, want %d", len(lookup), numPartitions)
+	}
+	m := &Maglev{}
+	m.setKeyHasher(XXHasher{})
+	s := &state{
+		config:        &m.config,
+		numPartitions: numPartitions,
+	}
+	s.nodes = make([]string, len(nodes))
+	copy(s.nodes, nodes)
+	s.sortNodes(s.nodes)
+	s.nodes = dedupSorted(s.nodes)
+	s.members = membersOf(s.nodes)
+	if uint64(len(s.nodes)) > numPartitions {
+		return nil, tooManyNodes(len(s.nodes), numPartitions)
+	}
+
+	index := make(map[string]int32, len(s.nodes))
+	for i, node := range s.nodes {
+		index[node] = int32(i)
+	}
+	s.lookup = make([]int32, numPartitions)
+	for partition, node := range lookup {
+		idx, ok := index[node]
+		if !ok {
+			return nil, fmt.Errorf("partition %d is owned by unknown node %q", partition, node)
+		}
+		s.lookup[partition] = idx
+	}
+	s.lookupNodes = s.nodes
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	m.store(s)
+	return m, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errMalformed
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
@@ -0,0 +1,189 @@
+package maglev
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewFromLookup(t *testing.T) {
+	src, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := src.LookupTable()
+	m, err := NewFromLookup(lookup, []string{"c", "b", "a"}, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 1000; key++ {
+		if got, want := m.Lookup(key), src.Lookup(key); got != want {
+			t.Fatalf("Lookup(%d) = %q, want %q", key, got, want)
+		}
+	}
+	for partition := 0; partition < 13; partition++ {
+		owner := lookup[partition]
+		if got := m.PreferenceList(partition); len(got) != 1 || got[0] != owner {
+			t.Errorf("PreferenceList(%d) = %v, want [%s]", partition, got, owner)
+		}
+	}
+	if got := m.LookupN(1, 3); len(got) != 1 || got[0] != src.Lookup(1) {
+		t.Errorf("LookupN(1, 3) = %v, want [%s]", got, src.Lookup(1))
+	}
+
+	invalid := []struct {
+		name          string
+		lookup        []string
+		nodes         []string
+		numPartitions uint64
+	}{
+		{"not prime", lookup[:12], []string{"a", "b", "c"}, 12},
+		{"short table", lookup[:11], []string{"a", "b", "c"}, 13},
+		{"unknown node", append(append([]string{}, lookup[:12]...), "d"), []string{"a", "b", "c"}, 13},
+		{"node without partitions", lookup, []string{"a", "b", "c", "d"}, 13},
+	}
+	for _, tt := range invalid {
+		if _, err := NewFromLookup(tt.lookup, tt.nodes, tt.numPartitions); err == nil {
+			t.Errorf("%s: NewFromLookup succeeded", tt.name)
+		}
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	m, err := NewWeightedMaglev(map[string]uint64{"a": 1, "b": 2, "c": 1}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMaglev(data, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 10000; key++ {
+		if got, want := loaded.Lookup(key), m.Lookup(key); got != want {
+			t.Fatalf("Lookup(%d) = %q after loading, want %q", key, got, want)
+		}
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// the loaded ring generates permutations once its nodes change
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(m) {
+		t.Error("loaded ring differs from the original after adding the same node")
+	}
+
+	for _, data := range [][]byte{nil, {0}, data[:len(data)-1], append(append([]byte{}, data...), 0)} {
+		if err := new(Maglev).UnmarshalBinary(data); err == nil {
+			t.Errorf("UnmarshalBinary(%v) succeeded", data)
+		}
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	// encode builds the encoding of a ring with nodes of weight 1 without checking it
+	encode := func(numPartitions uint64, nodes []string, lookup []uint64) []byte {
+		data := appendUvarint([]byte{encodingVersion}, numPartitions)
+		data = appendUvarint(data, uint64(len(nodes)))
+		for _, node := range nodes {
+			data = appendUvarint(data, uint64(len(node)))
+			data = append(data, node...)
+			data = appendUvarint(data, 1)
+		}
+		for _, idx := range lookup {
+			data = appendUvarint(data, idx)
+		}
+		return data
+	}
+	if _, err := LoadMaglev(encode(13, []string{"a", "b"}, []uint64{0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0}),
+		XXHasher{}, FNVHasher{}); err != nil {
+		t.Fatalf("LoadMaglev of a valid encoding failed: %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"more nodes than partitions": encode(2, []string{"a", "b", "c"}, []uint64{0, 1}),
+		"node without partitions":    encode(13, []string{"a", "b", "c"}, make([]uint64, 13)),
+	} {
+		m, err := LoadMaglev(data, XXHasher{}, FNVHasher{})
+		if !errors.Is(err, errMalformed) {
+			t.Errorf("%s: LoadMaglev = %v, want errMalformed", name, err)
+		}
+		if m != nil {
+			t.Errorf("%s: LoadMaglev returned a Maglev", name)
+		}
+	}
+
+	m, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := m.LookupTable()
+	if err := m.UnmarshalBinary(encode(13, []string{"a", "b", "c"}, make([]uint64, 13))); err == nil {
+		t.Fatal("UnmarshalBinary of a node without partitions succeeded")
+	}
+	if !reflect.DeepEqual(m.LookupTable(), want) || m.Generation() != 0 {
+		t.Error("failed UnmarshalBinary changed Maglev")
+	}
+}
+
+func TestMarshalBinaryStale(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.MarshalBinary(); !errors.Is(err, ErrStale) {
+		t.Errorf("MarshalBinary of a stale Maglev returned %v, want ErrStale", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMaglev([]string{"c", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Error("rings with different nodes share a fingerprint")
+	}
+	if _, err := b.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Error("equal rings have different fingerprints")
+	}
+	if got, want := a.Clone().Fingerprint(), a.Fingerprint(); got != want {
+		t.Errorf("clone has fingerprint %#x, want %#x", got, want)
+	}
+	// the fingerprint must not depend on the process
+	if got, want := a.Fingerprint(), uint64(0x13fe5f9560d85564); got != want {
+		t.Errorf("fingerprint of a, b, c at 13 partitions is %#x, want %#x", got, want)
+	}
+
+	before := a.Fingerprint()
+	if _, err := a.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Fingerprint() == before {
+		t.Error("Add did not change the fingerprint")
+	}
+	c, err := NewMaglev([]string{"a", "b", "c", "d"}, 17, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Error("rings with different numbers of partitions share a fingerprint")
+	}
+}
@@ -1,3 +1,5 @@
 module maglev
 
-go 1.12
+go 1.19
+
+require github.com/cespare/xxhash/v2 v2.3.0
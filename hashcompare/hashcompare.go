@@ -0,0 +1,21 @@
+// Package hashcompare provides alternative consistent hashing algorithms behind a common
+// interface, so that their balance and disruption can be compared with Maglev using the
+// same code.
+package hashcompare
+
+import "maglev"
+
+// Router routes keys to nodes. It is implemented by *maglev.Maglev and *HRW.
+type Router interface {
+	// Lookup returns the node the key belongs to, or the empty string if there are no nodes.
+	Lookup(key uint64) string
+	// Add adds nodes and returns the number of nodes added.
+	Add(nodes ...string) (int, error)
+	// Remove removes nodes and returns the number of nodes removed.
+	Remove(nodes ...string) (int, error)
+}
+
+var (
+	_ Router = (*maglev.Maglev)(nil)
+	_ Router = (*HRW)(nil)
+)
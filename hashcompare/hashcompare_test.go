@@ -0,0 +1,60 @@
+package hashcompare
+
+import (
+	"errors"
+	"testing"
+
+	"maglev"
+)
+
+func TestRouters(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	h1, h2 := maglev.DefaultHashers()
+	m, err := maglev.NewMaglev(nodes, 10007, h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routers := map[string]Router{
+		"maglev": m,
+		"hrw":    NewHRW(append(nodes, "a"), h1),
+	}
+	const numKeys = 20000
+	for name, r := range routers {
+		before := make([]string, numKeys)
+		counts := make(map[string]int)
+		for key := range before {
+			before[key] = r.Lookup(uint64(key) * 7919)
+			counts[before[key]]++
+		}
+		for _, node := range nodes {
+			// each node gets about a quarter of the keys
+			if n := counts[node]; n < numKeys/5 || n > numKeys*3/10 {
+				t.Errorf("%s: node %q got %d of %d keys", name, node, n, numKeys)
+			}
+		}
+		if len(counts) != len(nodes) {
+			t.Errorf("%s: keys routed to %v", name, counts)
+		}
+
+		if removed, err := r.Remove("b"); err != nil || removed != 1 {
+			t.Fatalf("%s: Remove = %d, %v", name, removed, err)
+		}
+		moved := 0
+		for key, node := range before {
+			got := r.Lookup(uint64(key) * 7919)
+			if got == "b" || got == "" {
+				t.Fatalf("%s: key %d routed to %q after removing b", name, key, got)
+			}
+			if got != node {
+				moved++
+			}
+		}
+		// the keys of b have to move, and Maglev moves a few others between the nodes
+		if moved < counts["b"] || moved > counts["b"]*11/10 {
+			t.Errorf("%s: removing b moved %d keys, want about %d", name, moved, counts["b"])
+		}
+		if _, err := r.Remove("a", "c", "d"); !errors.Is(err, maglev.ErrEmptyRing) {
+			t.Errorf("%s: removing all nodes returned %v, want ErrEmptyRing", name, err)
+		}
+	}
+}
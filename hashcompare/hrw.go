@@ -0,0 +1,104 @@
+package hashcompare
+
+import (
+	"sync"
+
+	"maglev"
+)
+
+// HRW implements rendezvous (highest random weight) hashing: a key belongs to the node with
+// the highest score for it. Lookups take time linear in the number of nodes, but removing a
+// node only moves the keys it owned.
+type HRW struct {
+	mu     sync.RWMutex
+	hasher maglev.Hasher
+	nodes  []hrwNode
+}
+
+type hrwNode struct {
+	name string
+	hash uint64
+}
+
+// NewHRW returns an HRW with the given nodes that hashes node names with h. Duplicate nodes
+// are ignored.
+func NewHRW(nodes []string, h maglev.Hasher) *HRW {
+	r := &HRW{hasher: h}
+	r.add(nodes)
+	return r
+}
+
+// Lookup returns the node the key belongs to, or the empty string if HRW has no nodes.
+func (r *HRW) Lookup(key uint64) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var owner string
+	var best uint64
+	for i, node := range r.nodes {
+		// break ties by name so that the result does not depend on the order of the nodes
+		if s := score(node.hash, key); i == 0 || s > best || (s == best && node.name < owner) {
+			owner, best = node.name, s
+		}
+	}
+	return owner
+}
+
+// Add adds new nodes to HRW and returns the number of nodes added.
+func (r *HRW) Add(nodes ...string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.add(nodes), nil
+}
+
+func (r *HRW) add(nodes []string) int {
+	added := 0
+	for _, node := range nodes {
+		if r.index(node) < 0 {
+			r.nodes = append(r.nodes, hrwNode{node, r.hasher.Hash(node)})
+			added++
+		}
+	}
+	return added
+}
+
+// Remove removes nodes from HRW and returns the number of nodes removed. Like Maglev, it
+// returns maglev.ErrEmptyRing and leaves HRW unchanged if the removal would remove all nodes.
+func (r *HRW) Remove(nodes ...string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		if r.index(node) >= 0 {
+			removed[node] = struct{}{}
+		}
+	}
+	if len(removed) == len(r.nodes) {
+		return 0, maglev.ErrEmptyRing
+	}
+	kept := make([]hrwNode, 0, len(r.nodes)-len(removed))
+	for _, node := range r.nodes {
+		if _, ok := removed[node.name]; !ok {
+			kept = append(kept, node)
+		}
+	}
+	r.nodes = kept
+	return len(removed), nil
+}
+
+// index returns the position of the node in r.nodes, or -1 if it is not present.
+func (r *HRW) index(node string) int {
+	for i, n := range r.nodes {
+		if n.name == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// score mixes the hash of a node with the key using the SplitMix64 finalizer.
+func score(node, key uint64) uint64 {
+	z := node ^ key*0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
@@ -0,0 +1,165 @@
+package maglev
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// FNVHasher hashes strings with 64-bit FNV-1a.
+type FNVHasher struct{}
+
+// Hash implements Hasher.
+func (FNVHasher) Hash(s string) uint64 {
+	return fnv64a(s)
+}
+
+// HashBytes implements ByteHasher.
+func (FNVHasher) HashBytes(b []byte) uint64 {
+	return fnv64a(b)
+}
+
+// fnv64a computes the same hash as hash/fnv.New64a without allocating.
+func fnv64a[T string | []byte](data T) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(data); i++ {
+		h ^= uint64(data[i])
+		h *= prime64
+	}
+	return h
+}
+
+// XXHasher hashes strings with 64-bit xxHash.
+type XXHasher struct{}
+
+// Hash implements Hasher.
+func (XXHasher) Hash(s string) uint64 {
+	return xxhash.Sum64String(s)
+}
+
+// HashBytes implements ByteHasher.
+func (XXHasher) HashBytes(b []byte) uint64 {
+	return xxhash.Sum64(b)
+}
+
+// SeededHasher hashes strings with 64-bit xxHash initialized with Seed. Hashers with
+// different seeds behave like unrelated hash functions.
+type SeededHasher struct {
+	Seed uint64
+}
+
+// Hash implements Hasher.
+func (h SeededHasher) Hash(s string) uint64 {
+	var d xxhash.Digest
+	d.ResetWithSeed(h.Seed)
+	_, _ = d.WriteString(s)
+	return d.Sum64()
+}
+
+// HashBytes implements ByteHasher.
+func (h SeededHasher) HashBytes(b []byte) uint64 {
+	var d xxhash.Digest
+	d.ResetWithSeed(h.Seed)
+	_, _ = d.Write(b)
+	return d.Sum64()
+}
+
+// SeededHashers returns a pair of hashers for use as h1 and h2 that are derived from a single
+// seed, so processes sharing the seed construct identical rings. The seeds of the two hashers
+// are the first two outputs of SplitMix64 seeded with seed, which keeps them apart even for
+// adjacent seeds.
+func SeededHashers(seed uint64) (h1, h2 Hasher) {
+	return SeededHasher{splitmix64(seed, 1)}, SeededHasher{splitmix64(seed, 2)}
+}
+
+// splitmix64 returns the i-th output of SplitMix64 seeded with seed.
+func splitmix64(seed, i uint64) uint64 {
+	z := seed + i*0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// DefaultHashers returns a pair of hashers suitable for use as h1 and h2 in NewMaglev.
+// The two are based on unrelated hash functions, so the offset and skip derived from
+// them are independent.
+func DefaultHashers() (h1, h2 Hasher) {
+	return XXHasher{}, FNVHasher{}
+}
+
+// maxHasherCorrelation is the largest absolute correlation between the outputs of h1 and h2
+// accepted by ValidateHashers.
+const maxHasherCorrelation = 0.9
+
+// ValidateHashers hashes the samples with h1 and h2 and returns an error if the hashers are
+// not suitable for use together, i.e. if either produces constant output or if their outputs
+// are identical or highly correlated. At least two distinct samples are required.
+func ValidateHashers(h1, h2 Hasher, samples []string) error {
+	if h1 == nil || h2 == nil {
+		return errNilHasher
+	}
+	if len(samples) < 2 {
+		return errors.New("at least two samples are required")
+	}
+	x := make([]float64, len(samples))
+	y := make([]float64, len(samples))
+	identical := true
+	for i, s := range samples {
+		a, b := h1.Hash(s), h2.Hash(s)
+		if a != b {
+			identical = false
+		}
+		x[i], y[i] = float64(a), float64(b)
+	}
+	if identical {
+		return errors.New("hashers produce identical output")
+	}
+	r, ok := correlation(x, y)
+	if !ok {
+		return errors.New("hasher produces constant output")
+	}
+	if math.Abs(r) > maxHasherCorrelation {
+		return fmt.Errorf("hashers are highly correlated (r=%.3f)", r)
+	}
+	return nil
+}
+
+// correlation returns the Pearson correlation coefficient of x and y. It returns false if
+// either has zero variance.
+func correlation(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i] / n
+		meanY += y[i] / n
+	}
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// validationSamples returns the samples used to validate hashers in strict mode: the nodes
+// plus a fixed set of synthetic names, so that small node sets are still meaningful.
+func validationSamples(nodes []string) []string {
+	samples := make([]string, 0, len(nodes)+64)
+	samples = append(samples, nodes...)
+	for i := 0; i < 64; i++ {
+		samples = append(samples, "maglev-sample-"+strconv.Itoa(i))
+	}
+	return samples
+}
@@ -0,0 +1,110 @@
+package maglev
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+func TestDefaultHashers(t *testing.T) {
+	h1, h2 := DefaultHashers()
+	for _, s := range []string{"", "a", "node-1", "10.0.0.1:8080"} {
+		if h1.Hash(s) == h2.Hash(s) {
+			t.Errorf("default hashers agree on %q", s)
+		}
+	}
+	if _, err := NewMaglev([]string{"a", "b", "c"}, 13, h1, h2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFNVHasher(t *testing.T) {
+	for _, s := range []string{"", "a", "node-1"} {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		if got, want := (FNVHasher{}).Hash(s), h.Sum64(); got != want {
+			t.Errorf("FNVHasher.Hash(%q) = %x, want %x", s, got, want)
+		}
+		if got, want := (FNVHasher{}).HashBytes([]byte(s)), h.Sum64(); got != want {
+			t.Errorf("FNVHasher.HashBytes(%q) = %x, want %x", s, got, want)
+		}
+	}
+}
+
+func TestValidateHashers(t *testing.T) {
+	samples := validationSamples([]string{"a", "b", "c"})
+	if err := ValidateHashers(XXHasher{}, FNVHasher{}, samples); err != nil {
+		t.Errorf("ValidateHashers of independent hashers: %v", err)
+	}
+	if err := ValidateHashers(XXHasher{}, XXHasher{}, samples); err == nil {
+		t.Error("ValidateHashers of identical hashers succeeded")
+	}
+	h1, h2 := SeededHashers(1)
+	if err := ValidateHashers(h1, h2, samples); err != nil {
+		t.Errorf("ValidateHashers of seeded hashers: %v", err)
+	}
+	if err := ValidateHashers(XXHasher{}, FNVHasher{}, samples[:1]); err == nil {
+		t.Error("ValidateHashers with a single sample succeeded")
+	}
+
+	if _, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, XXHasher{}, WithStrictValidation()); err == nil {
+		t.Error("NewMaglev with identical hashers and strict validation succeeded")
+	}
+	if _, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{}, WithStrictValidation()); err != nil {
+		t.Errorf("NewMaglev with strict validation: %v", err)
+	}
+}
+
+// countingHasher is an XXHasher that counts how it is called.
+type countingHasher struct {
+	strings, bytes int
+}
+
+func (h *countingHasher) Hash(s string) uint64 {
+	h.strings++
+	return XXHasher{}.Hash(s)
+}
+
+func (h *countingHasher) HashBytes(b []byte) uint64 {
+	h.bytes++
+	return XXHasher{}.HashBytes(b)
+}
+
+func TestByteHasher(t *testing.T) {
+	h := &countingHasher{}
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{}, WithKeyHasher(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.LookupBytes([]byte("key")), m.Lookup(XXHasher{}.Hash("key")); got != want {
+		t.Errorf("LookupBytes = %q, want %q", got, want)
+	}
+	if h.bytes != 1 || h.strings != 0 {
+		t.Errorf("LookupBytes called HashBytes %d and Hash %d times, want 1 and 0", h.bytes, h.strings)
+	}
+}
+
+func TestSeededMaglev(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	a, err := NewSeededMaglev(nodes, 1009, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSeededMaglev([]string{"d", "c", "b", "a"}, 1009, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(b) {
+		t.Error("rings with the same seed and nodes differ")
+	}
+	c, err := NewSeededMaglev(nodes, 1009, 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(c) {
+		t.Error("rings with adjacent seeds are equal")
+	}
+	h1, h2 := SeededHashers(42)
+	if h1.Hash("a") == h2.Hash("a") {
+		t.Error("the hashers of a seed agree")
+	}
+}
@@ -0,0 +1,81 @@
+package maglev
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// KeyCache memoizes the partitions of string keys of a Maglev, so that hot keys are not
+// hashed on every lookup. It holds at most a fixed number of keys, evicting the least recently
+// used one when full. Cached partitions are discarded whenever Maglev is modified. A KeyCache
+// is safe for concurrent use.
+//
+// Every lookup takes a lock shared by all callers and updates the recency of the key, which
+// costs more than hashing a short key with the default XXHasher, and serializes lookups that
+// would not block each other with LookupString. A KeyCache therefore only pays off with a key
+// hasher that is expensive compared to that, e.g. a cryptographic one set with WithKeyHasher;
+// BenchmarkKeyCache compares both cases with LookupString.
+type KeyCache struct {
+	m       *Maglev
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *keyCacheEntry, most recently used first
+}
+
+type keyCacheEntry struct {
+	key        string
+	partition  int
+	generation uint64 // generation of the state the partition was computed for
+}
+
+// NewKeyCache returns a KeyCache for m that holds up to size keys.
+func NewKeyCache(m *Maglev, size int) (*KeyCache, error) {
+	if size <= 0 {
+		return nil, errors.New("cache size must be positive")
+	}
+	return &KeyCache{
+		m:       m,
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+	}, nil
+}
+
+// Lookup returns the node the key belongs to, like LookupString on the underlying Maglev.
+func (c *KeyCache) Lookup(key string) string {
+	s := c.m.load()
+	s.observeLookups(1)
+	return s.owner(uint64(c.partition(s, key)))
+}
+
+// partition returns the partition of the key in s, computing and caching it if it is not
+// cached for the generation of s.
+func (c *KeyCache) partition(s *state, key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*keyCacheEntry)
+		if entry.generation != s.generation {
+			entry.partition = s.partitionID(s.hashKey(key))
+			entry.generation = s.generation
+		}
+		c.lru.MoveToFront(e)
+		return entry.partition
+	}
+	entry := &keyCacheEntry{key, s.partitionID(s.hashKey(key)), s.generation}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*keyCacheEntry).key)
+	}
+	return entry.partition
+}
+
+// Len returns the number of keys in the cache.
+func (c *KeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
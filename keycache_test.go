@@ -0,0 +1,90 @@
+package maglev
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestKeyCache(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewKeyCache(m, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 8; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, key := range keys {
+			if got, want := c.Lookup(key), m.LookupString(key); got != want {
+				t.Errorf("%s: Lookup(%q) = %q, want %q", when, key, got, want)
+			}
+		}
+	}
+	check("initially")
+	// the cached partitions are invalid once the number of partitions changes
+	if err := m.Resize(1009); err != nil {
+		t.Fatal(err)
+	}
+	check("after Resize")
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	check("after Add")
+
+	for i := 0; i < 16; i++ {
+		c.Lookup(fmt.Sprintf("other-%d", i))
+	}
+	if c.Len() != 8 {
+		t.Errorf("Len() = %d, want 8", c.Len())
+	}
+
+	if _, err := NewKeyCache(m, 0); err == nil {
+		t.Error("NewKeyCache with size 0 succeeded")
+	}
+}
+
+// sha256Hasher is a key hasher that is expensive compared to a KeyCache lookup.
+type sha256Hasher struct{}
+
+func (sha256Hasher) Hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.LittleEndian.Uint64(sum[:])
+}
+
+func BenchmarkKeyCache(b *testing.B) {
+	var keys []string
+	for i := 0; i < 256; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	for _, tt := range []struct {
+		name string
+		h    Hasher
+	}{{"xxhash", XXHasher{}}, {"sha256", sha256Hasher{}}} {
+		m, err := NewMaglev([]string{"a", "b", "c", "d"}, 65537, XXHasher{}, FNVHasher{}, WithKeyHasher(tt.h))
+		if err != nil {
+			b.Fatal(err)
+		}
+		c, err := NewKeyCache(m, len(keys))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(tt.name+"/LookupString", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.LookupString(keys[i%len(keys)])
+			}
+		})
+		b.Run(tt.name+"/KeyCache", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Lookup(keys[i%len(keys)])
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package maglev_test
+
+import (
+	"fmt"
+	"testing"
+
+	"maglev"
+	"maglev/maglevtest"
+)
+
+func TestLookupConsistent(t *testing.T) {
+	for _, numPartitions := range []uint64{2, 13, 101, 1009, 65537} {
+		for _, numNodes := range []int{1, 2, 5} {
+			if uint64(numNodes) > numPartitions {
+				continue
+			}
+			t.Run(fmt.Sprintf("%d/%d", numPartitions, numNodes), func(t *testing.T) {
+				var nodes []string
+				for i := 0; i < numNodes; i++ {
+					nodes = append(nodes, fmt.Sprintf("node-%d", i))
+				}
+				h1, h2 := maglev.DefaultHashers()
+				m, err := maglev.NewMaglev(nodes, numPartitions, h1, h2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				samples := 10000
+				if numPartitions < uint64(samples) {
+					samples = int(numPartitions)
+				}
+				maglevtest.AssertLookupConsistent(t, m, samples)
+			})
+		}
+	}
+}
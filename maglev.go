@@ -2,160 +2,1732 @@ package maglev
 
 import (
 	"errors"
-	"math/big"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
 	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Hasher hashes strings to uint64.
+// Errors returned by this package, possibly wrapped with more context. Use errors.Is to check
+// for them.
+var (
+	// ErrEmptyRing is returned when an operation would leave Maglev without nodes, or needs
+	// nodes that Maglev does not have.
+	ErrEmptyRing = errors.New("there are no nodes left")
+	// ErrNotPrime is returned when the number of partitions is not a prime.
+	ErrNotPrime = errors.New("number of partitions must be prime")
+	// ErrTooManyNodes is returned when there would be more nodes than partitions.
+	ErrTooManyNodes = errors.New("number of nodes exceed number of partitions")
+	// ErrNodeNotFound is returned when an operation refers to a node that is not in Maglev.
+	ErrNodeNotFound = errors.New("node not found")
+	// ErrStale is returned by methods that need an up-to-date lookup table when the nodes of a
+	// Maglev created with WithDeferredRebuild have changed since the last call to Rebuild.
+	ErrStale = errors.New("lookup table is out of date, call Rebuild first")
+)
+
+// ErrEmpty is the former name of ErrEmptyRing.
+//
+// Deprecated: Use ErrEmptyRing.
+var ErrEmpty = ErrEmptyRing
+
+var (
+	errNilHasher  = errors.New("hashers must not be nil")
+	errMaxLoad    = errors.New("max load factor must be at least 1")
+	errOutOfRange = errors.New("partition id out of range")
+	errNoHashers  = errors.New("maglev has no hashers, see NewFromLookup")
+	errUnchanged  = errors.New("unchanged") // returned to modify if there is nothing to publish
+)
+
+// checkWeight returns an error unless the weight is small enough that the weights of as many
+// nodes as there are partitions add up without overflowing a uint64, as WithMaxLoadFactor and
+// ExpectedShares require.
+func checkWeight(weight, numPartitions uint64) error {
+	if numPartitions > 0 && weight > math.MaxUint64/numPartitions {
+		return fmt.Errorf("node weight %d is too large for %d partitions", weight, numPartitions)
+	}
+	return nil
+}
+
+// tooManyNodes returns ErrTooManyNodes with the number of nodes and partitions.
+func tooManyNodes(nodes int, numPartitions uint64) error {
+	return fmt.Errorf("%w: %d nodes, %d partitions", ErrTooManyNodes, nodes, numPartitions)
+}
+
+// Hasher hashes strings to uint64. Maglev may call a Hasher from several goroutines at once,
+// so it must be safe for concurrent use.
 type Hasher interface {
 	Hash(string) uint64
 }
 
+// ByteHasher hashes byte slices to uint64. A Hasher that also implements ByteHasher must
+// return the same value for a byte slice as for the equivalent string.
+type ByteHasher interface {
+	HashBytes([]byte) uint64
+}
+
 // Maglev is the main object of this package.
+//
+// The lookup table only depends on the set of nodes, their weights, the number of partitions
+// and the hashers, so processes that arrive at the same node set through different sequences
+// of Add and Remove calls route every key identically.
+//
+// Any string is a valid node name, including the empty string: the lookup table refers to
+// nodes by index, so no name is reserved to mark unassigned partitions. Lookups on a Maglev
+// without nodes return the empty string, so a ring that may contain a node named "" must be
+// checked with Size to tell the two apart.
+//
+// A Maglev is safe for concurrent use. Its nodes and lookup table are held in an immutable
+// snapshot that read-only methods such as Lookup load atomically, without taking a lock.
+// Add, Remove and the other mutators are serialized by a mutex; they build a new snapshot
+// off to the side and publish it once it is complete, so readers never wait for a rebuild
+// and never observe a partially updated ring.
+//
+// The zero value is an empty Maglev without nodes or partitions, e.g. for a struct field that
+// is initialized lazily. Read-only methods treat it like a ring without nodes, and mutators
+// return an error, since it has no hashers to generate permutations with.
 type Maglev struct {
-	permutations  map[string][]uint64
-	lookup        []string
+	mu       sync.Mutex // serializes writers
+	state    atomic.Pointer[state]
+	standbys []*Maglev // guarded by mu, see AttachReplica
+	config
+}
+
+// config holds the settings of a Maglev, which do not change after construction.
+type config struct {
+	h1, h2       Hasher
+	keyHasher    Hasher
+	keyBytes     ByteHasher // keyHasher if it implements ByteHasher, nil otherwise
+	deferRebuild bool
+	strict       bool
+	autoPrime    bool
+	less         func(a, b string) bool // custom node order, see nodeLess
+	hashOrder    bool                   // order nodes by h1, see WithHashOrder
+	maxLoad      float64                // see WithMaxLoadFactor, 0 if unbounded
+	permCache    *permutationCache      // see WithPermutationCache, nil if disabled
+	replicas     int
+	observer     Observer
+}
+
+// state is a snapshot of the nodes and the lookup table of a Maglev. A state is never
+// modified once it has been published; writers modify a copy, which shares the slices and
+// maps of the original, so these must be replaced rather than modified in place.
+type state struct {
+	*config
+	permutations  [][]uint64 // permutations[i*replicas+j] belongs to replica j of nodes[i]
+	lookup        []int32    // lookup[i] is the index of the node owning partition i in lookupNodes
+	lookupNodes   []string   // the nodes the lookup table was populated from
 	nodes         []string
+	members       map[string]struct{} // the elements of nodes
+	weights       map[string]uint64
+	meta          map[string]any      // see SetMeta
+	pins          map[uint64]string   // see Pin
+	draining      map[string]struct{} // see DrainNode
 	numPartitions uint64
-	h1, h2        Hasher
+	stale         bool   // nodes changed since the lookup table was last populated
+	generation    uint64 // incremented whenever a modified state is published
+	counts        *countsCache
 }
 
-// NewMaglev initializes a Maglev hasher.
-func NewMaglev(nodes []string, numPartitions uint64, h1, h2 Hasher) (*Maglev, error) {
-	// check if numPartitions is prime
-	if !big.NewInt(0).SetUint64(numPartitions).ProbablyPrime(0) {
-		return nil, errors.New("number of partitions must be prime")
-	}
+// NewMaglev initializes a Maglev hasher with numPartitions partitions, which must be a prime
+// and therefore at least 2. nodes may be empty, e.g. for services that start before their
+// backends are discovered; lookups then return the empty string until nodes are added.
+// Duplicate nodes are ignored, so {a, a, b} yields the same ring as {a, b}, unless
+// WithStrictValidation is used, in which case they are an error.
+func NewMaglev(nodes []string, numPartitions uint64, h1, h2 Hasher, opts ...Option) (*Maglev, error) {
+	return NewBuilder().Nodes(nodes...).Partitions(numPartitions).Hashers(h1, h2).Options(opts...).Build()
+}
 
-	nodescopy := make([]string, len(nodes))
-	copy(nodescopy, nodes)
-	sort.Strings(nodescopy)
+// NewWeightedMaglev initializes a Maglev hasher whose nodes are weighted. A node
+// receives a share of the partitions proportional to its weight, so a node with
+// weight 3 owns roughly three times as many partitions as a node with weight 1.
+func NewWeightedMaglev(nodes map[string]uint64, numPartitions uint64, h1, h2 Hasher, opts ...Option) (*Maglev, error) {
+	return NewBuilder().Weights(nodes).Partitions(numPartitions).Hashers(h1, h2).Options(opts...).Build()
+}
+
+// NewSeededMaglev initializes a Maglev hasher whose hashers are derived from seed with
+// SeededHashers. Processes that use the same seed, nodes and number of partitions construct
+// identical rings without exchanging hashers or lookup tables.
+func NewSeededMaglev(nodes []string, numPartitions uint64, seed uint64, opts ...Option) (*Maglev, error) {
+	h1, h2 := SeededHashers(seed)
+	return NewMaglev(nodes, numPartitions, h1, h2, opts...)
+}
 
+func newMaglev(nodes []string, weights map[string]uint64, numPartitions uint64, h1, h2 Hasher, opts []Option) (*Maglev, error) {
 	m := &Maglev{
-		nodes:         nodescopy,
+		config: config{
+			h1: h1,
+			h2: h2,
+		},
+	}
+	m.setKeyHasher(XXHasher{})
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.autoPrime {
+		numPartitions = NextPrime(numPartitions)
+	}
+	if m.maxLoad != 0 && !(m.maxLoad >= 1) {
+		return nil, errMaxLoad
+	}
+
+	if err := checkPartitions(numPartitions); err != nil {
+		return nil, err
+	}
+	for _, w := range weights {
+		if err := checkWeight(w, numPartitions); err != nil {
+			return nil, err
+		}
+	}
+	if h1 == nil || h2 == nil || m.keyHasher == nil {
+		return nil, errNilHasher
+	}
+
+	s := &state{
+		config:        &m.config,
+		weights:       weights,
 		numPartitions: numPartitions,
-		h1:            h1,
-		h2:            h2,
+	}
+	s.nodes = make([]string, len(nodes))
+	copy(s.nodes, nodes)
+	s.sortNodes(s.nodes)
+	s.nodes = dedupSorted(s.nodes)
+	s.members = membersOf(s.nodes)
+	if m.strict && len(s.nodes) != len(nodes) {
+		return nil, errors.New("duplicate nodes")
+	}
+	if uint64(len(s.nodes)) > s.numPartitions {
+		return nil, tooManyNodes(len(s.nodes), s.numPartitions)
+	}
+
+	if m.strict {
+		if err := ValidateHashers(h1, h2, validationSamples(s.nodes)); err != nil {
+			return nil, err
+		}
 	}
 	if len(nodes) > 0 {
-		m.generatePermutations()
-		m.populateLookup()
+		s.generatePermutations()
+		s.populateLookup()
 	}
 
+	m.store(s)
 	return m, nil
 }
 
-func (m *Maglev) generatePermutations() {
-	m.permutations = make(map[string][]uint64)
-	for _, node := range m.nodes {
-		m.permutations[node] = m.generatePermutationsForNode(node)
+// zeroState is the state of a zero-value Maglev, which has neither nodes nor partitions.
+var zeroState = &state{config: &config{}}
+
+// load returns the current state, or zeroState if Maglev is a zero value.
+func (m *Maglev) load() *state {
+	if s := m.state.Load(); s != nil {
+		return s
+	}
+	return zeroState
+}
+
+// store publishes s as the current state.
+func (m *Maglev) store(s *state) {
+	s.counts = &countsCache{owner: s}
+	m.state.Store(s)
+}
+
+// modify applies fn to a copy of the current state and publishes the copy if fn succeeds,
+// together with the states of the standbys it is mirrored to, see AttachReplica. Readers keep
+// using the current state until then. If fn returns errUnchanged, the copy is discarded and
+// modify returns nil.
+func (m *Maglev) modify(fn func(s *state) error) error {
+	tx, err := m.prepare(fn)
+	if err != nil || tx == nil {
+		return err
 	}
+	tx.commit()
+	return nil
+}
+
+// pending is a state that has been prepared but not published yet. The Maglev it belongs to
+// stays locked until it is committed or aborted.
+type pending struct {
+	m        *Maglev
+	old, s   *state
+	start    time.Time
+	standbys []*pending
 }
 
-func (m *Maglev) generatePermutationsForNode(node string) []uint64 {
-	offset := m.h1.Hash(node) % m.numPartitions
-	skip := m.h2.Hash(node)%(m.numPartitions-1) + 1
+// prepare locks m and applies fn to a copy of the current state, mirroring the result to the
+// standbys of m. It returns nil if there is nothing to publish, in which case m is unlocked.
+func (m *Maglev) prepare(fn func(s *state) error) (*pending, error) {
+	m.mu.Lock()
+	tx := &pending{m: m, old: m.load()}
+	s := *tx.old
+	tx.s = &s
+	if m.observer != nil {
+		tx.start = time.Now()
+	}
+	if err := fn(&s); err != nil {
+		m.mu.Unlock()
+		if err == errUnchanged {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, r := range m.standbys {
+		standby, err := r.prepare(func(rs *state) error {
+			return rs.mirror(&s)
+		})
+		if err != nil {
+			tx.abort()
+			return nil, fmt.Errorf("standby: %w", err)
+		}
+		if standby != nil {
+			tx.standbys = append(tx.standbys, standby)
+		}
+	}
+	return tx, nil
+}
+
+// commit publishes the prepared states and unlocks their Maglevs, then reports the rebuilds
+// to the observers, which may thus call into any of the Maglevs.
+func (tx *pending) commit() {
+	tx.publish()
+	tx.report()
+}
 
-	permutation := make([]uint64, m.numPartitions)
-	for i := uint64(0); i < m.numPartitions; i++ {
-		permutation[i] = (offset + i*skip) % m.numPartitions
+func (tx *pending) publish() {
+	tx.s.updatePermutationCache(tx.old)
+	tx.s.generation++
+	tx.m.store(tx.s)
+	tx.m.mu.Unlock()
+	for _, standby := range tx.standbys {
+		standby.publish()
+	}
+}
+
+func (tx *pending) report() {
+	m, old, s := tx.m, tx.old, tx.s
+	if m.observer != nil && s.lookup != nil && !sameTable(old.lookup, s.lookup) {
+		m.observer.OnRebuild(time.Since(tx.start), len(s.changedSince(old)))
+	}
+	for _, standby := range tx.standbys {
+		standby.report()
+	}
+}
+
+// abort discards the prepared states and unlocks their Maglevs.
+func (tx *pending) abort() {
+	for _, standby := range tx.standbys {
+		standby.abort()
+	}
+	tx.m.mu.Unlock()
+}
+
+// parallelPermutationsMin is the number of permutation entries from which generatePermutations
+// spreads the work over several goroutines. Below it, starting them costs more than it saves.
+const parallelPermutationsMin = 1 << 18
+
+// generatePermutations generates the permutations of all nodes. The permutations of different
+// nodes are independent, so for large rings they are generated by up to GOMAXPROCS goroutines,
+// each filling the slots of the nodes it takes; the result is the same as when generated
+// sequentially.
+func (s *state) generatePermutations() {
+	replicas := s.numReplicas()
+	s.permutations = make([][]uint64, len(s.nodes)*replicas)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(s.nodes) {
+		workers = len(s.nodes)
+	}
+	if workers < 2 || uint64(len(s.permutations))*s.numPartitions < parallelPermutationsMin {
+		for i, node := range s.nodes {
+			copy(s.permutations[i*replicas:], s.generatePermutationsForNode(node))
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(s.nodes); i = int(next.Add(1) - 1) {
+				copy(s.permutations[i*replicas:], s.generatePermutationsForNode(s.nodes[i]))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ensurePermutations generates the permutations if they are missing, e.g. because the
+// Maglev was restored with UnmarshalBinary.
+func (s *state) ensurePermutations() {
+	if len(s.permutations) != len(s.nodes)*s.numReplicas() {
+		s.generatePermutations()
+	}
+}
+
+// numReplicas returns the number of permutations per node, see WithReplicas.
+func (c *config) numReplicas() int {
+	if c.replicas < 1 {
+		return 1
+	}
+	return c.replicas
+}
+
+// replicaName returns the name under which the permutation of replica j of the node is
+// generated. Without replicas, it is the name of the node itself.
+func (c *config) replicaName(node string, j int) string {
+	if c.numReplicas() == 1 {
+		return node
+	}
+	return node + "#" + strconv.Itoa(j)
+}
+
+// generatePermutationsForNode returns the permutations of all replicas of the node.
+func (s *state) generatePermutationsForNode(node string) [][]uint64 {
+	permutations := make([][]uint64, s.numReplicas())
+	for j := range permutations {
+		permutations[j] = s.generatePermutation(s.replicaName(node, j))
+	}
+	return permutations
+}
+
+func (s *state) generatePermutation(name string) []uint64 {
+	offset, skip := s.offsetAndSkip(name)
+
+	// permutation[i] = (offset + i*skip) % numPartitions, computed incrementally
+	permutation := make([]uint64, s.numPartitions)
+	c := offset
+	for i := range permutation {
+		permutation[i] = c
+		c += skip
+		if c >= s.numPartitions {
+			c -= s.numPartitions
+		}
 	}
 	return permutation
 }
 
-func (m *Maglev) populateLookup() {
-	N := len(m.nodes)
+func (s *state) offsetAndSkip(name string) (offset, skip uint64) {
+	offset = s.h1.Hash(name) % s.numPartitions
+	skip = s.h2.Hash(name)%(s.numPartitions-1) + 1
+	return offset, skip
+}
+
+// rank returns the earliest position of the partition in the permutations of the node's
+// replicas.
+func (s *state) rank(node string, partition uint64) uint64 {
+	rank := s.permutationRank(s.replicaName(node, 0), partition)
+	for j := 1; j < s.numReplicas(); j++ {
+		if r := s.permutationRank(s.replicaName(node, j), partition); r < rank {
+			rank = r
+		}
+	}
+	return rank
+}
+
+// permutationRank returns the position of the partition in the permutation generated for
+// name, i.e. the i for which offset + i*skip = partition (mod numPartitions).
+func (s *state) permutationRank(name string, partition uint64) uint64 {
+	offset, skip := s.offsetAndSkip(name)
+	diff := (partition + s.numPartitions - offset) % s.numPartitions
+	// numPartitions is prime, so the inverse of skip is skip^(numPartitions-2)
+	inv := powmod(skip, s.numPartitions-2, s.numPartitions)
+	return mulmod(diff, inv, s.numPartitions)
+}
+
+func mulmod(a, b, mod uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return bits.Rem64(hi, lo, mod)
+}
+
+func powmod(base, exp, mod uint64) uint64 {
+	result := uint64(1) % mod
+	for base %= mod; exp > 0; exp >>= 1 {
+		if exp&1 == 1 {
+			result = mulmod(result, base, mod)
+		}
+		base = mulmod(base, base, mod)
+	}
+	return result
+}
+
+// populateScratch holds the buffers populateLookup needs while it runs. They are pooled so
+// that frequent rebuilds do not allocate them every time. The lookup table itself is not
+// reused, since previously published states may still refer to it.
+type populateScratch struct {
+	next    []int    // next[e] is the position of the next candidate in permutations[e]
+	weights []uint64 // weights[i] is the weight of nodes[i]
+	credit  []uint64 // credit[i] is the weight nodes[i] has accumulated towards its next claim
+	turns   []uint64 // turns[i] is the number of claims nodes[i] has made, to rotate replicas
+	claimed []uint64 // claimed[i] is the number of partitions owned by nodes[i], see loadCaps
+	caps    []uint64 // caps[i] is the maximum number of partitions of nodes[i]
+}
+
+var populateScratchPool = sync.Pool{
+	New: func() interface{} { return new(populateScratch) },
+}
+
+// reset resizes the buffers to the given lengths and zeroes next.
+func (p *populateScratch) reset(permutations, nodes int) {
+	if cap(p.next) < permutations {
+		p.next = make([]int, permutations)
+	}
+	p.next = p.next[:permutations]
+	for i := range p.next {
+		p.next[i] = 0
+	}
+	if cap(p.weights) < nodes {
+		p.weights = make([]uint64, nodes)
+		p.credit = make([]uint64, nodes)
+		p.turns = make([]uint64, nodes)
+		p.claimed = make([]uint64, nodes)
+		p.caps = make([]uint64, nodes)
+	}
+	p.weights = p.weights[:nodes]
+	p.credit = p.credit[:nodes]
+	p.turns = p.turns[:nodes]
+	p.claimed = p.claimed[:nodes]
+	p.caps = p.caps[:nodes]
+	for i := range p.claimed {
+		p.turns[i] = 0
+		p.claimed[i] = 0
+	}
+}
+
+// loadCaps sets caps[i] to the maximum number of partitions nodes[i] may own under
+// WithMaxLoadFactor: its share of the partitions by weight, times the load factor, rounded up.
+// The caps add up to at least numPartitions, so populateLookup always terminates.
+func (s *state) loadCaps(weights, caps []uint64) {
+	var total uint64
+	for _, w := range weights {
+		total += w
+	}
+	for i, w := range weights {
+		// the exact share rounded up, in case the float computation rounds below it
+		hi, lo := bits.Mul64(s.numPartitions, w)
+		share, rem := bits.Div64(hi, lo, total)
+		if rem != 0 {
+			share++
+		}
+		caps[i] = uint64(math.Ceil(s.maxLoad * float64(s.numPartitions) * float64(w) / float64(total)))
+		if caps[i] < share {
+			caps[i] = share
+		}
+	}
+}
+
+func (s *state) populateLookup() {
+	s.populateUntil(s.numPartitions)
+}
+
+// populateUntil populates the lookup table like populateLookup, but stops as soon as the
+// given partition has been claimed, leaving the remaining partitions unassigned. It is used
+// to find the owner of a single partition without populating the whole table.
+func (s *state) populateUntil(partition uint64) {
+	N := len(s.nodes)
 	if N == 0 {
 		panic("cannot populate lookup table without nodes")
 	}
-	m.lookup = make([]string, m.numPartitions)
-	next := make([]int, N)
+	s.lookup = make([]int32, s.numPartitions)
+	for i := range s.lookup {
+		s.lookup[i] = -1
+	}
+	s.lookupNodes = s.nodes
+	s.stale = false
+	replicas := s.numReplicas()
+	scratch := populateScratchPool.Get().(*populateScratch)
+	defer populateScratchPool.Put(scratch)
+	scratch.reset(len(s.permutations), N)
+	next, weights, credit, turns := scratch.next, scratch.weights, scratch.credit, scratch.turns
+	claimed, caps := scratch.claimed, scratch.caps
+	var maxWeight uint64
+	for i, ID := range s.nodes {
+		weights[i] = s.weight(ID)
+		if weights[i] > maxWeight {
+			maxWeight = weights[i]
+		}
+	}
+	for i := range credit {
+		// every node claims a slot in the first round
+		credit[i] = maxWeight - weights[i]
+	}
+	bounded := s.maxLoad != 0
+	if bounded {
+		s.loadCaps(weights, caps)
+	}
 	var n uint64
+	// pinned partitions are assigned up front, so the nodes skip them like claimed ones
+	for p, node := range s.pins {
+		i := s.search(s.nodes, node)
+		s.lookup[p] = int32(i)
+		claimed[i]++
+		n++
+	}
+	if _, ok := s.pins[partition]; ok || n == s.numPartitions {
+		return
+	}
 	for {
-		for i, ID := range m.nodes {
-			c := m.permutations[ID][next[i]]
-			for m.lookup[c] != "" {
-				next[i]++
-				c = m.permutations[ID][next[i]]
+		for i := range s.nodes {
+			// a node earns its weight in credit every round and claims a slot whenever its
+			// credit reaches the largest weight, so nodes claim in proportion to their weights
+			// regardless of their scale, and at most once per round. credit stays below
+			// maxWeight, so it cannot overflow.
+			if credit[i] < maxWeight-weights[i] {
+				credit[i] += weights[i]
+				continue
+			}
+			credit[i] -= maxWeight - weights[i]
+			if bounded && claimed[i] >= caps[i] {
+				// the slots the node skips go to the nodes that claim them next
+				continue
 			}
-			m.lookup[c] = ID
-			next[i]++
+			// take turns among the permutations of the node's replicas
+			e := i*replicas + int(turns[i]%uint64(replicas))
+			turns[i]++
+			permutation := s.permutations[e]
+			c := permutation[next[e]]
+			for s.lookup[c] >= 0 {
+				next[e]++
+				c = permutation[next[e]]
+			}
+			s.lookup[c] = int32(i)
+			next[e]++
+			claimed[i]++
 			n++
-			if n == m.numPartitions {
+			if n == s.numPartitions || c == partition {
 				return
 			}
 		}
 	}
 }
 
-// Lookup returns the node the key belongs to.
+// weight returns the weight of the node. Nodes without an explicit weight have weight 1.
+func (s *state) weight(node string) uint64 {
+	if w, ok := s.weights[node]; ok {
+		return w
+	}
+	return 1
+}
+
+// Lookup returns the node the key belongs to, or the empty string if Maglev has no nodes.
 func (m *Maglev) Lookup(key uint64) string {
-	partitionID := m.PartitionID(key)
-	return m.lookup[partitionID]
+	s := m.load()
+	s.observeLookups(1)
+	return s.owner(uint64(s.partitionID(key)))
+}
+
+// LookupIndex returns the position of the node the key belongs to in Nodes, e.g. to index a
+// slice of connections, or -1 if Maglev has no nodes. With WithDeferredRebuild, it is also -1
+// if the node has been removed since the last Rebuild. Positions shift when nodes are added or
+// removed, so a slice indexed by them must be rebuilt whenever the nodes change, see Generation.
+func (m *Maglev) LookupIndex(key uint64) int {
+	s := m.load()
+	s.observeLookups(1)
+	if s.lookup == nil {
+		return -1
+	}
+	idx := int(s.lookup[s.partitionID(key)])
+	if !s.stale {
+		return idx
+	}
+	// the lookup table refers to the nodes it was populated from
+	node := s.lookupNodes[idx]
+	if idx = s.search(s.nodes, node); idx == len(s.nodes) || s.nodes[idx] != node {
+		return -1
+	}
+	return idx
+}
+
+// LookupAll returns the nodes the keys belong to, in the order of the keys. All keys are
+// resolved against the same snapshot of the lookup table.
+func (m *Maglev) LookupAll(keys []uint64) []string {
+	nodes := make([]string, len(keys))
+	m.load().lookupAll(keys, nodes)
+	m.observeLookups(len(keys))
+	return nodes
+}
+
+// LookupAllInto is like LookupAll, but writes the nodes to dst instead of allocating a new
+// slice. Returns an error if dst is shorter than keys.
+func (m *Maglev) LookupAllInto(keys []uint64, dst []string) error {
+	if len(dst) < len(keys) {
+		return errors.New("destination is shorter than keys")
+	}
+	m.load().lookupAll(keys, dst)
+	m.observeLookups(len(keys))
+	return nil
+}
+
+func (s *state) lookupAll(keys []uint64, dst []string) {
+	for i, key := range keys {
+		dst[i] = s.owner(uint64(s.partitionID(key)))
+	}
+}
+
+// LookupString hashes the key with the key hasher and returns the node it belongs to.
+func (m *Maglev) LookupString(key string) string {
+	return m.Lookup(m.hashKey(key))
+}
+
+// LookupBytes hashes the key with the key hasher and returns the node it belongs to. It
+// returns the same node as LookupString for the equivalent string, but does not allocate if
+// the key hasher implements ByteHasher.
+func (m *Maglev) LookupBytes(key []byte) string {
+	if m.keyBytes != nil {
+		return m.Lookup(m.keyBytes.HashBytes(key))
+	}
+	return m.Lookup(m.hashKey(string(key)))
+}
+
+// hashKey hashes the key with the key hasher, which is only missing in a zero-value Maglev.
+func (c *config) hashKey(key string) uint64 {
+	if c.keyHasher == nil {
+		return XXHasher{}.Hash(key)
+	}
+	return c.keyHasher.Hash(key)
+}
+
+// setKeyHasher sets the key hasher, detecting whether it can hash byte slices directly so
+// that lookups do not need a type assertion.
+func (m *Maglev) setKeyHasher(h Hasher) {
+	m.keyHasher = h
+	m.keyBytes, _ = h.(ByteHasher)
+}
+
+// LookupN returns up to n distinct nodes for the key in order of preference. The first node
+// is the one returned by Lookup; the others are the remaining nodes ordered by how early the
+// key's partition appears in their permutations relative to their weight, which makes them
+// deterministic backups for failover that favor heavier nodes. If n exceeds the number of
+// nodes, all nodes are returned.
+func (m *Maglev) LookupN(key uint64, n int) []string {
+	s := m.load()
+	s.observeLookups(1)
+	if n > len(s.nodes) {
+		n = len(s.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	partition := uint64(s.partitionID(key))
+	owner := s.owner(partition)
+	result := make([]string, 0, n)
+	result = append(result, owner)
+	for _, node := range s.preferences(partition) {
+		if len(result) == n {
+			break
+		}
+		if node != owner {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// LookupReplicas is like LookupN, but orders the nodes after the first one randomly, weighted
+// by their weight, so that the load on backups such as read replicas is spread over all nodes
+// instead of falling on the same second choice for all keys of a partition. The order is
+// derived from the key, so it is the same in every call. rng maps a seed to a pseudo-random
+// number and must mix its input well; it is called once per node with the key combined with
+// the node name. A nil rng uses SplitMix64.
+func (m *Maglev) LookupReplicas(key uint64, n int, rng func(uint64) uint64) []string {
+	s := m.load()
+	s.observeLookups(1)
+	if n > len(s.nodes) {
+		n = len(s.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	if rng == nil {
+		rng = func(seed uint64) uint64 { return splitmix64(seed, 0) }
+	}
+	owner := s.owner(uint64(s.partitionID(key)))
+	type candidate struct {
+		node  string
+		score float64
+	}
+	candidates := make([]candidate, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		if node == owner {
+			continue
+		}
+		// weighted sampling without replacement: keep the nodes with the largest u^(1/weight)
+		// for u uniform in (0, 1], compared by their logarithms
+		u := (float64(rng(key^XXHasher{}.Hash(node))>>11) + 1) / (1 << 53)
+		candidates = append(candidates, candidate{node, math.Log(u) / float64(s.weight(node))})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	result := make([]string, 0, n)
+	result = append(result, owner)
+	for _, c := range candidates {
+		if len(result) == n {
+			break
+		}
+		result = append(result, c.node)
+	}
+	return result
+}
+
+// LookupHealthy returns the node the key belongs to if healthy reports it as healthy, and
+// otherwise the first healthy node in the key's failover order as returned by LookupN. Keys
+// owned by healthy nodes are therefore never moved. Returns the empty string if no node is
+// healthy. The nodes passed to healthy are taken from a snapshot loaded when LookupHealthy is
+// called, so healthy may call methods of m. A nil healthy treats all nodes as healthy.
+//
+// Keys that must be co-located, e.g. the sub-requests of a session, are looked up by the key
+// of their parent, so they land on the parent's node and fail over to the same node with it.
+func (m *Maglev) LookupHealthy(key uint64, healthy func(node string) bool) string {
+	s := m.load()
+	s.observeLookups(1)
+	partition := uint64(s.partitionID(key))
+	if s.lookup == nil {
+		return ""
+	}
+	owner := s.owner(partition)
+	if healthy == nil || healthy(owner) {
+		return owner
+	}
+	for _, node := range s.preferences(partition) {
+		if node != owner && healthy(node) {
+			return node
+		}
+	}
+	return ""
+}
+
+// PreferenceList returns all nodes ordered by how early the partition appears in their
+// permutations relative to their weight, i.e. roughly the order in which they would have
+// claimed the partition while populating the lookup table. The first node is usually the owner
+// of the partition, but not always, since an earlier node may have been blocked by partitions
+// claimed in previous rounds. A Maglev without hashers, see NewFromLookup, only returns the
+// owner. Returns nil if the partition id is out of range.
+func (m *Maglev) PreferenceList(partitionID int) []string {
+	s := m.load()
+	if s.checkPartition(partitionID) != nil || s.lookup == nil {
+		return nil
+	}
+	if s.h1 == nil {
+		return []string{s.owner(uint64(partitionID))}
+	}
+	return s.preferences(uint64(partitionID))
+}
+
+// preferences returns the nodes ordered by the rank of the partition in their permutations
+// divided by their weight, breaking ties by node order. A node of weight w advances through its
+// permutation at a rate proportional to w while populating the lookup table, so rank/w
+// approximates when it would reach the partition. Returns nil if there are no hashers to compute
+// the ranks with, in which case only the owner of the partition is known.
+func (s *state) preferences(partition uint64) []string {
+	if s.h1 == nil {
+		return nil
+	}
+	type candidate struct {
+		node   string
+		rank   uint64
+		weight uint64
+	}
+	candidates := make([]candidate, len(s.nodes))
+	for i, node := range s.nodes {
+		candidates[i] = candidate{node, s.rank(node, partition), s.weight(node)}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		// rank_i/weight_i < rank_j/weight_j without rounding or overflow
+		hi1, lo1 := bits.Mul64(candidates[i].rank, candidates[j].weight)
+		hi2, lo2 := bits.Mul64(candidates[j].rank, candidates[i].weight)
+		return hi1 < hi2 || (hi1 == hi2 && lo1 < lo2)
+	})
+	nodes := make([]string, len(candidates))
+	for i, c := range candidates {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// LookupU128 returns the node the 128-bit key hi<<64 | lo belongs to. The partition is
+// derived from the full 128-bit value rather than a truncation to 64 bits.
+func (m *Maglev) LookupU128(hi, lo uint64) string {
+	s := m.load()
+	s.observeLookups(1)
+	if s.lookup == nil {
+		return ""
+	}
+	return s.owner(bits.Rem64(hi, lo, s.numPartitions))
+}
+
+// saltMultiplier spreads salts over the key space before they are mixed into keys. It is
+// the 64-bit golden ratio constant, which is odd, so distinct salts yield distinct values.
+const saltMultiplier = 0x9e3779b97f4a7c15
+
+// LookupWithSalt returns the node the key belongs to within the key space of the salt, e.g.
+// a tenant id. The same key maps to independent partitions under different salts, while a
+// salt of 0 is equivalent to Lookup.
+func (m *Maglev) LookupWithSalt(key, salt uint64) string {
+	return m.Lookup(key ^ salt*saltMultiplier)
+}
+
+// LookupCanary routes a fraction of the keys to shadow, e.g. a ring of canary nodes, and the
+// others to m. Whether a key falls into the fraction only depends on the key, so it is routed
+// to the same ring every time, and the keys in a fraction f are a subset of those in any larger
+// fraction. isShadow reports whether the node was taken from shadow. Keys are routed with m if
+// shadow is nil or has no nodes.
+func (m *Maglev) LookupCanary(key uint64, shadow *Maglev, fraction float64) (node string, isShadow bool) {
+	if shadow != nil && keyFraction(key, 3) < fraction {
+		if s := shadow.load(); s.lookup != nil {
+			m.observeLookups(1)
+			return s.owner(uint64(s.partitionID(key))), true
+		}
+	}
+	return m.Lookup(key), false
+}
+
+// keyFraction maps the key to [0, 1) with the i-th output of SplitMix64, independently of
+// its partition.
+func keyFraction(key, i uint64) float64 {
+	return float64(splitmix64(key, i)>>11) / (1 << 53)
 }
 
-// PartitionID returns the partition the key belongs to.
+// PartitionID returns the partition the key belongs to, an id in [0, Partitions()). It always
+// fits into an int, since Maglev rejects larger numbers of partitions, see NumPartitionsInt.
 func (m *Maglev) PartitionID(key uint64) int {
-	return int(key % uint64(m.numPartitions))
+	return m.load().partitionID(key)
+}
+
+func (s *state) partitionID(key uint64) int {
+	if s.numPartitions == 0 {
+		// zero-value Maglev
+		return 0
+	}
+	return int(key % s.numPartitions)
+}
+
+// PartitionIDString returns the partition the key belongs to after hashing it with the key
+// hasher, i.e. the partition of the node returned by LookupString.
+func (m *Maglev) PartitionIDString(key string) int {
+	return m.load().partitionID(m.hashKey(key))
+}
+
+// checkPartition returns an error unless the partition id is in [0, numPartitions). Methods
+// taking a partition id must check it, since it may not have been returned by PartitionID.
+func (s *state) checkPartition(partitionID int) error {
+	if partitionID < 0 || uint64(partitionID) >= s.numPartitions {
+		return errOutOfRange
+	}
+	return nil
+}
+
+// PartitionOwner returns the node owning the partition with the given id. Returns an error if
+// the id is not in [0, Partitions()), ErrStale if the lookup table is out of date, or
+// ErrEmptyRing if Maglev has no nodes.
+func (m *Maglev) PartitionOwner(partitionID int) (string, error) {
+	s := m.load()
+	if err := s.checkPartition(partitionID); err != nil {
+		return "", err
+	}
+	if s.stale {
+		return "", ErrStale
+	}
+	if s.lookup == nil {
+		return "", ErrEmptyRing
+	}
+	return s.owner(uint64(partitionID)), nil
+}
+
+// LookupTable returns a copy of the lookup table: the element at index i is the node owning
+// the partition with id i. Returns nil if Maglev has no nodes.
+func (m *Maglev) LookupTable() []string {
+	s := m.load()
+	if s.lookup == nil {
+		return nil
+	}
+	table := make([]string, len(s.lookup))
+	for i, idx := range s.lookup {
+		table[i] = s.lookupNodes[idx]
+	}
+	return table
+}
+
+// Range calls fn for each partition in order of id with the node owning it, until fn returns
+// false. It iterates over a snapshot of the lookup table taken when Range is called, so fn may
+// modify Maglev.
+func (m *Maglev) Range(fn func(partitionID int, node string) bool) {
+	s := m.load()
+	for partition, idx := range s.lookup {
+		if !fn(partition, s.lookupNodes[idx]) {
+			return
+		}
+	}
+}
+
+// Permutation returns a copy of the order in which the node claims partitions while the
+// lookup table is populated, e.g. to diagnose a hasher that gives a node an unexpected share.
+// With WithReplicas, it is the permutation of the node's first replica. ok is false if the node
+// is not in Maglev or Maglev has no hashers, see NewFromLookup.
+func (m *Maglev) Permutation(node string) (permutation []uint64, ok bool) {
+	s := m.load()
+	i := s.search(s.nodes, node)
+	if i == len(s.nodes) || s.nodes[i] != node || s.h1 == nil {
+		return nil, false
+	}
+	if len(s.permutations) == len(s.nodes)*s.numReplicas() {
+		return append([]uint64(nil), s.permutations[i*s.numReplicas()]...), true
+	}
+	// permutations are generated lazily after UnmarshalBinary
+	return s.generatePermutation(s.replicaName(node, 0)), true
+}
+
+// PartitionsFor returns the sorted ids of the partitions owned by the node, or nil if the
+// node is not in Maglev.
+func (m *Maglev) PartitionsFor(node string) []int {
+	s := m.load()
+	idx := s.search(s.lookupNodes, node)
+	if idx == len(s.lookupNodes) || s.lookupNodes[idx] != node {
+		return nil
+	}
+	var partitions []int
+	for partition, owner := range s.lookup {
+		if owner == int32(idx) {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
 }
 
 // Contains returns true if Maglev contains the node.
 func (m *Maglev) Contains(node string) bool {
+	return m.load().contains(node)
+}
+
+func (s *state) contains(node string) bool {
+	_, ok := s.members[node]
+	return ok
+}
+
+// membersOf returns the set of the nodes.
+func membersOf(nodes []string) map[string]struct{} {
+	members := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		members[node] = struct{}{}
+	}
+	return members
+}
+
+// nodeLess defines the order of the nodes, which is also the order in which they claim
+// partitions in populateLookup. The order only depends on the node names, so the lookup table
+// is determined by the set of nodes regardless of the order in which they were added.
+// Without a custom order, nodes are sorted by name, or by their h1 hash with WithHashOrder.
+func (c *config) nodeLess(a, b string) bool {
+	if c.less == nil {
+		if c.hashOrder && c.h1 != nil {
+			if ha, hb := c.h1.Hash(a), c.h1.Hash(b); ha != hb {
+				return ha < hb
+			}
+		}
+		return a < b
+	}
+	// break ties by name so that the order is total
+	return c.less(a, b) || (!c.less(b, a) && a < b)
+}
+
+// dedupSorted removes duplicates from sorted nodes in place. A duplicate would otherwise
+// claim partitions twice per round and make the lookup table depend on the input list rather
+// than the set of nodes.
+func dedupSorted(nodes []string) []string {
+	if len(nodes) == 0 {
+		return nodes
+	}
+	n := 1
+	for _, node := range nodes[1:] {
+		if node != nodes[n-1] {
+			nodes[n] = node
+			n++
+		}
+	}
+	return nodes[:n]
+}
+
+func (c *config) sortNodes(nodes []string) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return c.nodeLess(nodes[i], nodes[j])
+	})
+}
+
+// search returns the position of the node in nodes sorted by nodeLess, or the position at
+// which it would be inserted if it is not present.
+func (c *config) search(nodes []string, node string) int {
 	// binary search
-	if pos := sort.SearchStrings(m.nodes, node); m.nodes[pos] == node {
-		return true
+	return sort.Search(len(nodes), func(i int) bool {
+		return !c.nodeLess(nodes[i], node)
+	})
+}
+
+// Diff compares the desired nodes with the current ones and returns the sorted nodes that
+// need to be added and removed to reach the desired set. Duplicates in desired are ignored.
+func (m *Maglev) Diff(desired []string) (toAdd, toRemove []string) {
+	return m.load().diff(desired)
+}
+
+func (s *state) diff(desired []string) (toAdd, toRemove []string) {
+	want := make(map[string]struct{}, len(desired))
+	for _, node := range desired {
+		if _, ok := want[node]; ok {
+			continue
+		}
+		want[node] = struct{}{}
+		if !s.contains(node) {
+			toAdd = append(toAdd, node)
+		}
 	}
-	return false
+	for _, node := range s.nodes {
+		if _, ok := want[node]; !ok {
+			toRemove = append(toRemove, node)
+		}
+	}
+	sort.Strings(toAdd)
+	sort.Strings(toRemove)
+	return toAdd, toRemove
 }
 
-// Add adds new nodes to Maglev and returns the number of nodes added. Returns an error
-// if the addition causes number of nodes to exceed number of partitions. It is the responsibility
-// of the user to roll back any changes caused by this (e.g. by calling Remove() to revert the lookup table).
-func (m *Maglev) Add(nodes ...string) (int, error) {
-	n := 0
+// Add adds new nodes to Maglev and returns the number of nodes added. Nodes that are already
+// present are ignored, and a node passed more than once is added once. Returns an error
+// if the addition would cause number of nodes to exceed number of partitions, in which case
+// none of the nodes are added and Maglev is left unchanged.
+func (m *Maglev) Add(nodes ...string) (added int, err error) {
+	err = m.modify(func(s *state) error {
+		added, err = s.add(1, nodes)
+		return err
+	})
+	return added, err
+}
+
+// AddWeighted adds new nodes with the given weight to Maglev and returns the number of
+// nodes added. Nodes that are already present keep their current weight. Errors are
+// reported as in Add.
+func (m *Maglev) AddWeighted(weight uint64, nodes ...string) (added int, err error) {
+	if weight == 0 {
+		return 0, errors.New("node weight must be positive")
+	}
+	err = m.modify(func(s *state) error {
+		added, err = s.add(weight, nodes)
+		return err
+	})
+	return added, err
+}
+
+// Reweight changes the weight of a node that is already in Maglev, e.g. after its capacity
+// changed, and rebuilds the lookup table. The node keeps its permutations, so mostly the
+// partitions that the changed share requires move. Returns an error if the node is not in
+// Maglev or the weight is 0.
+func (m *Maglev) Reweight(node string, weight uint64) error {
+	if weight == 0 {
+		return errors.New("node weight must be positive")
+	}
+	return m.modify(func(s *state) error {
+		if !s.contains(node) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, node)
+		}
+		if s.h1 == nil {
+			return errNoHashers
+		}
+		if s.weight(node) == weight {
+			return errUnchanged
+		}
+		if err := checkWeight(weight, s.numPartitions); err != nil {
+			return err
+		}
+		s.setWeight(node, weight)
+		s.update()
+		return nil
+	})
+}
+
+// setWeight sets the weight of the node, copying the weights first since they may be shared
+// with the published state. Weight 1 is the default and is not stored.
+func (s *state) setWeight(node string, weight uint64) {
+	s.weights = s.copyWeights()
+	if weight == 1 {
+		delete(s.weights, node)
+	} else {
+		s.weights[node] = weight
+	}
+}
+
+func (s *state) add(weight uint64, nodes []string) (int, error) {
+	if s.h1 == nil {
+		return 0, errNoHashers
+	}
+	if err := checkWeight(weight, s.numPartitions); err != nil {
+		return 0, err
+	}
+	// validate the batch before changing anything
+	added := make(map[string]struct{}, len(nodes))
 	for _, node := range nodes {
-		// check if node doesn't exist yet
-		if pos := sort.SearchStrings(m.nodes, node); m.nodes[pos] != node {
-			// insert node
-			m.nodes = append(m.nodes[:pos], append([]string{node}, m.nodes[pos:]...)...)
-			m.permutations[node] = m.generatePermutationsForNode(node)
-			n++
+		if !s.contains(node) {
+			added[node] = struct{}{}
 		}
 	}
-	m.populateLookup()
-	if uint64(len(m.nodes)) > m.numPartitions {
-		return n, errors.New("number of nodes exceed number of partitions")
+	if uint64(len(s.nodes)+len(added)) > s.numPartitions {
+		return 0, tooManyNodes(len(s.nodes)+len(added), s.numPartitions)
+	}
+	if len(added) == 0 {
+		return 0, errUnchanged
 	}
-	return n, nil
+
+	s.insertNodes(added, weight)
+	s.update()
+	return len(added), nil
 }
 
-// Remove removes nodes from Maglev and returns the number of nodes removed. Returns an error
-// if removal causes number of nodes to be zero. In this case, the lookup table is not updated,
-// and it is the user's responsibility to roll back the changes (e.g. by calling Add() to add some nodes back).
-func (m *Maglev) Remove(nodes ...string) (int, error) {
-	n := 0
+// WouldMove returns true if the key would be assigned to a different node than it is now if
+// the node were added with weight 1. The prospective lookup table is only populated until the
+// key's partition is claimed, so this is cheaper than adding the node to a Clone. Returns false
+// if the node is already present or could not be added.
+func (m *Maglev) WouldMove(key uint64, node string) bool {
+	s := *m.load()
+	if s.contains(node) || uint64(len(s.nodes)) >= s.numPartitions || s.h1 == nil {
+		return false
+	}
+	partition := uint64(s.partitionID(key))
+	owner := s.owner(partition)
+	s.insertNodes(map[string]struct{}{node: {}}, 1)
+	s.populateUntil(partition)
+	return s.owner(partition) != owner
+}
+
+// RemovalImpact returns, for every partition the node owns, the node that would own it if the
+// node were removed, e.g. to warm the caches of its successors before removing it. Maglev is
+// not changed. Returns nil if the node is not in Maglev, is its only node or Maglev has no
+// hashers.
+func (m *Maglev) RemovalImpact(node string) map[int]string {
+	old := m.load()
+	if !old.contains(node) || len(old.nodes) == 1 || old.h1 == nil {
+		return nil
+	}
+	s := *old
+	s.deleteNodes(map[string]struct{}{node: {}})
+	s.populateLookup()
+	impact := make(map[int]string)
+	for partition := range old.lookup {
+		if old.owner(uint64(partition)) == node {
+			impact[partition] = s.owner(uint64(partition))
+		}
+	}
+	return impact
+}
+
+// Remove removes nodes from Maglev and returns the number of nodes removed. Returns ErrEmptyRing
+// if the removal would cause number of nodes to be zero, in which case none of the nodes are
+// removed and Maglev is left unchanged. Use Drain to remove all nodes.
+func (m *Maglev) Remove(nodes ...string) (removed int, err error) {
+	err = m.modify(func(s *state) error {
+		removed, err = s.remove(nodes)
+		return err
+	})
+	return removed, err
+}
+
+// RemoveFunc removes the nodes for which pred returns true and returns the number of nodes
+// removed, rebuilding the lookup table once. Errors are reported as in Remove. pred is called
+// while other mutators are blocked, so it must not modify m.
+func (m *Maglev) RemoveFunc(pred func(node string) bool) (removed int, err error) {
+	err = m.modify(func(s *state) error {
+		var nodes []string
+		for _, node := range s.nodes {
+			if pred(node) {
+				nodes = append(nodes, node)
+			}
+		}
+		removed, err = s.remove(nodes)
+		return err
+	})
+	return removed, err
+}
+
+func (s *state) remove(nodes []string) (int, error) {
+	if s.h1 == nil {
+		return 0, errNoHashers
+	}
+	// validate the batch before changing anything
+	removed := make(map[string]struct{}, len(nodes))
 	for _, node := range nodes {
-		// check if node really exists
-		if pos := sort.SearchStrings(m.nodes, node); m.nodes[pos] == node {
-			// delete node
-			m.nodes = append(m.nodes[:pos], m.nodes[pos+1:]...)
-			delete(m.permutations, node)
-			n++
+		if s.contains(node) {
+			removed[node] = struct{}{}
+		}
+	}
+	if len(removed) == len(s.nodes) {
+		return 0, ErrEmptyRing
+	}
+	if len(removed) == 0 {
+		return 0, errUnchanged
+	}
+
+	s.deleteNodes(removed)
+	s.update()
+	return len(removed), nil
+}
+
+// RemoveTo removes the node from Maglev and hands all of its partitions to successor, e.g. to
+// migrate the shard of a decommissioned node to a single other node. Unlike Remove, it leaves
+// the owners of all other partitions untouched, so the lookup table differs from the one a
+// rebuild would produce; the next Add, Remove or Rebuild redistributes the partitions as usual.
+// Pin the partitions to successor first to keep them there. Returns an error if either node is
+// not in Maglev, or ErrStale if the lookup table is out of date.
+func (m *Maglev) RemoveTo(node, successor string) error {
+	return m.modify(func(s *state) error {
+		return s.removeTo(node, successor)
+	})
+}
+
+func (s *state) removeTo(node, successor string) error {
+	if s.h1 == nil {
+		return errNoHashers
+	}
+	if s.stale {
+		return ErrStale
+	}
+	for _, n := range []string{node, successor} {
+		if !s.contains(n) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, n)
 		}
 	}
-	if uint64(len(m.nodes)) == 0 {
-		return n, errors.New("there are no nodes left")
+	if node == successor {
+		return errors.New("node cannot be its own successor")
+	}
+
+	// the lookup table is up to date, so it refers to the nodes by their index in s.nodes
+	from, to := int32(s.search(s.nodes, node)), int32(s.search(s.nodes, successor))
+	s.deleteNodes(map[string]struct{}{node: {}})
+	lookup := make([]int32, len(s.lookup))
+	for partition, idx := range s.lookup {
+		if idx == from {
+			idx = to
+		}
+		if idx > from {
+			idx--
+		}
+		lookup[partition] = idx
+	}
+	s.lookup = lookup
+	s.lookupNodes = s.nodes
+	return nil
+}
+
+// AddTracked is like Add, but also returns the sorted ids of the partitions whose owner
+// changed, e.g. to invalidate only the affected cache shards.
+func (m *Maglev) AddTracked(nodes ...string) (added int, changed []int, err error) {
+	err = m.modify(func(s *state) error {
+		old := *s
+		if added, err = s.add(1, nodes); err != nil {
+			return err
+		}
+		changed = s.changedSince(&old)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
 	}
-	m.populateLookup()
-	return n, nil
+	return added, changed, nil
+}
+
+// RemoveTracked is like Remove, but also returns the sorted ids of the partitions whose owner
+// changed.
+func (m *Maglev) RemoveTracked(nodes ...string) (removed int, changed []int, err error) {
+	err = m.modify(func(s *state) error {
+		old := *s
+		if removed, err = s.remove(nodes); err != nil {
+			return err
+		}
+		changed = s.changedSince(&old)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return removed, changed, nil
+}
+
+// changedSince returns the ids of the partitions whose owner differs from the one in the
+// lookup table of old. If the number of partitions differs, e.g. after Resize, every
+// partition counts as changed, since the ids no longer refer to the same keys.
+func (s *state) changedSince(old *state) []int {
+	var changed []int
+	resized := len(old.lookup) != len(s.lookup)
+	for partition := range s.lookup {
+		if resized || old.owner(uint64(partition)) != s.owner(uint64(partition)) {
+			changed = append(changed, partition)
+		}
+	}
+	return changed
+}
+
+// Reconcile adds and removes nodes so that the nodes of Maglev are exactly the desired ones,
+// rebuilding the lookup table once. Returns ErrEmptyRing if desired is empty, or an error if it
+// has more nodes than partitions, in which case Maglev is left unchanged. Added nodes have
+// weight 1; nodes that are kept retain their weight.
+func (m *Maglev) Reconcile(desired []string) error {
+	return m.modify(func(s *state) error {
+		return s.reconcile(desired)
+	})
+}
+
+func (s *state) reconcile(desired []string) error {
+	if s.h1 == nil {
+		return errNoHashers
+	}
+	toAdd, toRemove := s.diff(desired)
+	size := len(s.nodes) + len(toAdd) - len(toRemove)
+	if size == 0 {
+		return ErrEmptyRing
+	}
+	if uint64(size) > s.numPartitions {
+		return tooManyNodes(size, s.numPartitions)
+	}
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return errUnchanged
+	}
+
+	added := make(map[string]struct{}, len(toAdd))
+	for _, node := range toAdd {
+		added[node] = struct{}{}
+	}
+	removed := make(map[string]struct{}, len(toRemove))
+	for _, node := range toRemove {
+		removed[node] = struct{}{}
+	}
+	s.deleteNodes(removed)
+	s.insertNodes(added, 1)
+	s.update()
+	return nil
+}
+
+// insertNodes inserts nodes that are not present yet, without updating the lookup table.
+func (s *state) insertNodes(added map[string]struct{}, weight uint64) {
+	s.ensurePermutations()
+	// the slices and maps are shared with the published state, so they must not be
+	// modified in place
+	s.nodes = append(make([]string, 0, len(s.nodes)+len(added)), s.nodes...)
+	s.permutations = append(make([][]uint64, 0, len(s.permutations)+len(added)*s.numReplicas()), s.permutations...)
+	s.members = membersOf(s.nodes)
+	if weight != 1 {
+		s.weights = s.copyWeights()
+	}
+	for node := range added {
+		// insert node
+		pos := s.search(s.nodes, node)
+		s.nodes = append(s.nodes[:pos], append([]string{node}, s.nodes[pos:]...)...)
+		permutations := s.permutationsForNode(node)
+		at := pos * s.numReplicas()
+		s.permutations = append(s.permutations[:at], append(permutations, s.permutations[at:]...)...)
+		s.members[node] = struct{}{}
+		if weight != 1 {
+			s.weights[node] = weight
+		}
+	}
+}
+
+// deleteNodes deletes nodes that are present, without updating the lookup table.
+func (s *state) deleteNodes(removed map[string]struct{}) {
+	s.ensurePermutations()
+	// the slices and maps are shared with the published state, so they must not be
+	// modified in place
+	s.nodes = append([]string(nil), s.nodes...)
+	s.permutations = append([][]uint64(nil), s.permutations...)
+	s.members = membersOf(s.nodes)
+	if s.weights != nil {
+		s.weights = s.copyWeights()
+	}
+	if s.meta != nil {
+		s.meta = s.copyMeta()
+	}
+	if s.draining != nil {
+		s.draining = s.copyDraining()
+	}
+	if s.pins != nil {
+		pins := make(map[uint64]string, len(s.pins))
+		for partition, node := range s.pins {
+			if _, ok := removed[node]; !ok {
+				pins[partition] = node
+			}
+		}
+		s.pins = pins
+	}
+	for node := range removed {
+		// delete node
+		pos := s.search(s.nodes, node)
+		s.nodes = append(s.nodes[:pos], s.nodes[pos+1:]...)
+		at := pos * s.numReplicas()
+		s.permutations = append(s.permutations[:at], s.permutations[at+s.numReplicas():]...)
+		delete(s.members, node)
+		delete(s.weights, node)
+		delete(s.meta, node)
+		delete(s.draining, node)
+	}
+}
+
+// copyWeights returns a copy of the weights that can be modified.
+func (s *state) copyWeights() map[string]uint64 {
+	weights := make(map[string]uint64, len(s.weights))
+	for node, w := range s.weights {
+		weights[node] = w
+	}
+	return weights
+}
+
+// update rebuilds the lookup table after the nodes changed, unless rebuilds are deferred.
+func (s *state) update() {
+	if s.deferRebuild {
+		s.stale = true
+	} else {
+		s.populateLookup()
+	}
+}
+
+// Stale returns true if the nodes of a Maglev created with WithDeferredRebuild have changed
+// since the last call to Rebuild, i.e. if lookups do not reflect the current nodes yet.
+func (m *Maglev) Stale() bool {
+	return m.load().stale
+}
+
+// Rebuild repopulates the lookup table from the current nodes. It is only needed for a
+// Maglev created with WithDeferredRebuild, where it should be called once after a series
+// of Add and Remove calls. If the lookup table is up to date, Rebuild does nothing.
+func (m *Maglev) Rebuild() {
+	_ = m.modify(func(s *state) error {
+		// without hashers the nodes cannot change, so the lookup table is up to date
+		if s.h1 == nil || !s.stale {
+			return errUnchanged
+		}
+		if len(s.nodes) == 0 {
+			s.lookup = nil
+			s.lookupNodes = nil
+			s.stale = false
+			return nil
+		}
+		s.ensurePermutations()
+		s.populateLookup()
+		return nil
+	})
+}
+
+// Drain removes all nodes from Maglev. A drained Maglev returns the empty string from Lookup
+// until nodes are added again. Draining a Maglev without nodes does nothing.
+func (m *Maglev) Drain() {
+	_ = m.modify(func(s *state) error {
+		if len(s.nodes) == 0 && !s.stale {
+			return errUnchanged
+		}
+		s.drain()
+		return nil
+	})
+}
+
+func (s *state) drain() {
+	s.nodes = nil
+	s.members = nil
+	s.weights = nil
+	s.meta = nil
+	s.pins = nil
+	s.draining = nil
+	s.permutations = nil
+	s.lookup = nil
+	s.lookupNodes = nil
+	s.stale = false
+}
+
+// Resize changes the number of partitions of Maglev to newNumPartitions, which must be a prime
+// larger than the current number of partitions. All permutations and the lookup table are
+// regenerated before the resized ring is published, so lookups never observe a partially
+// resized ring.
+func (m *Maglev) Resize(newNumPartitions uint64) error {
+	if err := checkPartitions(newNumPartitions); err != nil {
+		return err
+	}
+	return m.modify(func(s *state) error {
+		if s.h1 == nil {
+			return errNoHashers
+		}
+		if newNumPartitions <= s.numPartitions {
+			return errors.New("number of partitions can only grow")
+		}
+		for _, w := range s.weights {
+			if err := checkWeight(w, newNumPartitions); err != nil {
+				return err
+			}
+		}
+		s.numPartitions = newNumPartitions
+		s.generatePermutations()
+		if len(s.nodes) > 0 {
+			s.populateLookup()
+		}
+		return nil
+	})
+}
+
+// Generation returns a counter that is incremented whenever Maglev changes, e.g. by Add,
+// Remove, Reconcile or Resize, but not by calls that leave it unchanged such as adding a node
+// that is already present. Caches derived from Maglev can compare generations to detect that
+// they are out of date.
+func (m *Maglev) Generation() uint64 {
+	return m.load().generation
 }
 
 // Size returns the number of nodes in Maglev.
 func (m *Maglev) Size() int {
-	return len(m.nodes)
+	return len(m.load().nodes)
+}
+
+// Nodes returns a sorted copy of the nodes in Maglev.
+func (m *Maglev) Nodes() []string {
+	s := m.load()
+	nodes := make([]string, len(s.nodes))
+	copy(nodes, s.nodes)
+	return nodes
 }
 
 // Partitions returns the number of partitions of Maglev.
 func (m *Maglev) Partitions() uint64 {
-	return m.numPartitions
+	return m.load().numPartitions
+}
+
+// NumPartitionsInt returns the number of partitions of Maglev as an int, e.g. to size slices
+// indexed by partition id. Maglev rejects numbers of partitions that do not fit into an int,
+// which would only be possible on 32-bit platforms, so the error is always nil for a Maglev
+// created by this package.
+func (m *Maglev) NumPartitionsInt() (int, error) {
+	n := m.load().numPartitions
+	if n > math.MaxInt {
+		return 0, errors.New("number of partitions overflows int")
+	}
+	return int(n), nil
+}
+
+// Clone returns an independent copy of m, so a snapshot can be inspected or mutated without
+// affecting m. The copy starts out sharing the immutable state of m, so cloning is cheap.
+func (m *Maglev) Clone() *Maglev {
+	c := &Maglev{config: m.config}
+	if m.permCache != nil {
+		c.permCache = newPermutationCache(m.permCache.size)
+	}
+	s := *m.load()
+	s.config = &c.config
+	c.store(&s)
+	return c
+}
+
+// Merge returns a new Maglev over the union of the nodes of m and other, with the hashers,
+// options and number of partitions of m. Nodes keep their weight and metadata; a node present
+// in both keeps those of m. Returns an error if the numbers of partitions differ or the union
+// has more nodes than partitions. m and other are not modified.
+func (m *Maglev) Merge(other *Maglev) (*Maglev, error) {
+	c := m.Clone()
+	s, o := *c.load(), other.load()
+	if s.numPartitions != o.numPartitions {
+		return nil, errors.New("number of partitions differ")
+	}
+	// group the new nodes by weight, since insertNodes adds nodes of a single weight
+	added := make(map[uint64]map[string]struct{})
+	n := 0
+	for _, node := range o.nodes {
+		if s.contains(node) {
+			continue
+		}
+		w := o.weight(node)
+		if added[w] == nil {
+			added[w] = make(map[string]struct{})
+		}
+		added[w][node] = struct{}{}
+		n++
+	}
+	if n == 0 {
+		return c, nil
+	}
+	if s.h1 == nil {
+		return nil, errNoHashers
+	}
+	if uint64(len(s.nodes)+n) > s.numPartitions {
+		return nil, tooManyNodes(len(s.nodes)+n, s.numPartitions)
+	}
+	if o.meta != nil {
+		s.meta = s.copyMeta()
+	}
+	for w, nodes := range added {
+		s.insertNodes(nodes, w)
+		for node := range nodes {
+			if meta, ok := o.meta[node]; ok {
+				s.meta[node] = meta
+			}
+		}
+	}
+	s.update()
+	c.store(&s)
+	return c, nil
+}
+
+// Equal returns true if m and other have the same number of partitions, the same nodes
+// and identical lookup tables, i.e. if they route every key to the same node.
+func (m *Maglev) Equal(other *Maglev) bool {
+	if m == other {
+		return true
+	}
+	s, o := m.load(), other.load()
+	if s.numPartitions != o.numPartitions || len(s.nodes) != len(o.nodes) {
+		return false
+	}
+	for i, node := range s.nodes {
+		if o.nodes[i] != node {
+			return false
+		}
+	}
+	for i := uint64(0); i < s.numPartitions; i++ {
+		if s.owner(i) != o.owner(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Disruption compares the lookup tables of two Maglev instances with the same number of
+// partitions and returns the number and fraction of partitions that are assigned to a
+// different node in new than in old. The fraction is 0 for rings without partitions, such as
+// zero-value Maglevs.
+func Disruption(old, new *Maglev) (moved int, fraction float64, err error) {
+	if old == new {
+		return 0, 0, nil
+	}
+	o, n := old.load(), new.load()
+	if o.numPartitions != n.numPartitions {
+		return 0, 0, errors.New("number of partitions differ")
+	}
+	if o.numPartitions == 0 {
+		return 0, 0, nil
+	}
+	for i := uint64(0); i < o.numPartitions; i++ {
+		if o.owner(i) != n.owner(i) {
+			moved++
+		}
+	}
+	return moved, float64(moved) / float64(o.numPartitions), nil
+}
+
+// Blend routes a fraction ratio of the keys with new and the others with old, e.g. to move keys
+// to a resized ring gradually. Whether a key is routed with new only depends on the key, so
+// as ratio grows from 0 to 1, every key moves to new exactly once and never back. Keys are
+// routed with old if new has no nodes, and with new if old has no nodes.
+func Blend(old, new *Maglev, key uint64, ratio float64) string {
+	if keyFraction(key, 4) < ratio && new.load().lookup != nil || old.load().lookup == nil {
+		return new.Lookup(key)
+	}
+	return old.Lookup(key)
+}
+
+// owner returns the node owning the partition, or the empty string if the lookup table
+// has not been populated.
+func (s *state) owner(partition uint64) string {
+	if s.lookup == nil {
+		return ""
+	}
+	return s.lookupNodes[s.lookup[partition]]
 }
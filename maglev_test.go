@@ -0,0 +1,1828 @@
+package maglev
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRebuildUnchanged(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := m.Generation()
+	m.Rebuild()
+	if m.Generation() != gen {
+		t.Errorf("Rebuild of an up-to-date Maglev bumped the generation to %d, want %d", m.Generation(), gen)
+	}
+
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	m.Rebuild()
+	if m.Stale() {
+		t.Error("Maglev is stale after Rebuild")
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainUnchanged(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+	if m.Size() != 0 || m.Lookup(1) != "" {
+		t.Fatalf("Maglev has %d nodes after Drain", m.Size())
+	}
+	gen := m.Generation()
+	m.Drain()
+	if m.Generation() != gen {
+		t.Errorf("Drain of an empty Maglev bumped the generation to %d, want %d", m.Generation(), gen)
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+		node  string
+		want  bool
+	}{
+		{"empty ring", nil, "a", false},
+		{"sorts first", []string{"b", "c"}, "a", false},
+		{"sorts last", []string{"a", "b"}, "c", false},
+		{"between", []string{"a", "c"}, "b", false},
+		{"first", []string{"a", "b", "c"}, "a", true},
+		{"last", []string{"a", "b", "c"}, "c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMaglev(tt.nodes, 13, XXHasher{}, FNVHasher{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Contains(tt.node); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.node, got, tt.want)
+			}
+			// adding and removing around the sort boundaries must not panic either
+			if !tt.want {
+				if _, err := m.Add(tt.node); err != nil {
+					t.Fatal(err)
+				}
+				if !m.Contains(tt.node) {
+					t.Errorf("Contains(%q) = false after Add", tt.node)
+				}
+				if len(tt.nodes) > 0 {
+					if _, err := m.Remove(tt.node); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := m.Validate(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestConcurrentLookups is meant to be run with -race.
+func TestConcurrentLookups(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	m, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for key := uint64(i); ; key += 8 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if m.Lookup(key) == "" {
+					t.Errorf("Lookup(%d) returned no node", key)
+					return
+				}
+				if p := m.PartitionID(key); p < 0 || uint64(p) >= m.Partitions() {
+					t.Errorf("PartitionID(%d) = %d out of range", key, p)
+					return
+				}
+				m.Contains("e")
+				m.Size()
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := m.Add("e"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Remove("e"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestLookupString(t *testing.T) {
+	for _, h := range []Hasher{nil, FNVHasher{}, SeededHasher{Seed: 7}} {
+		var opts []Option
+		want := Hasher(XXHasher{})
+		if h != nil {
+			opts = append(opts, WithKeyHasher(h))
+			want = h
+		}
+		m, err := NewMaglev([]string{"a", "b", "c", "d"}, 101, XXHasher{}, FNVHasher{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"", "user-1", "user-2", "10.0.0.1"} {
+			if got, want := m.LookupString(key), m.Lookup(want.Hash(key)); got != want {
+				t.Errorf("LookupString(%q) = %q, want %q", key, got, want)
+			}
+			if got, want := m.LookupBytes([]byte(key)), m.LookupString(key); got != want {
+				t.Errorf("LookupBytes(%q) = %q, want %q", key, got, want)
+			}
+		}
+	}
+}
+
+func TestLookupN(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e"}
+	m, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 1000; key++ {
+		all := m.LookupN(key, len(nodes)+1)
+		if len(all) != len(nodes) {
+			t.Fatalf("LookupN(%d, %d) returned %d nodes, want %d", key, len(nodes)+1, len(all), len(nodes))
+		}
+		if all[0] != m.Lookup(key) {
+			t.Fatalf("LookupN(%d) starts with %q, want Lookup's %q", key, all[0], m.Lookup(key))
+		}
+		seen := make(map[string]bool)
+		for _, node := range all {
+			if seen[node] {
+				t.Fatalf("LookupN(%d) = %v repeats %q", key, all, node)
+			}
+			seen[node] = true
+		}
+		for n := 0; n <= len(nodes); n++ {
+			if got := m.LookupN(key, n); fmt.Sprint(got) != fmt.Sprint(all[:n]) {
+				t.Fatalf("LookupN(%d, %d) = %v, want prefix %v", key, n, got, all[:n])
+			}
+		}
+	}
+}
+
+func TestDisruption(t *testing.T) {
+	var nodes []string
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	old, err := NewMaglev(nodes, 65537, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	new := old.Clone()
+	if _, err := new.Add("node-10"); err != nil {
+		t.Fatal(err)
+	}
+	moved, fraction, err := Disruption(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the new node takes 1/11 of the partitions, and few others move between old nodes
+	if ideal := 1.0 / 11; fraction < ideal || fraction > ideal*1.1 {
+		t.Errorf("adding a node to 10 moved %d partitions (%.4f), want about %.4f", moved, fraction, ideal)
+	}
+	if moved, _, _ := Disruption(old, old.Clone()); moved != 0 {
+		t.Errorf("Disruption of a clone = %d, want 0", moved)
+	}
+
+	other, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Disruption(old, other); err == nil {
+		t.Error("Disruption of rings with different numbers of partitions succeeded")
+	}
+	if moved, fraction, err := Disruption(&Maglev{}, &Maglev{}); moved != 0 || fraction != 0 || err != nil {
+		t.Errorf("Disruption of zero-value Maglevs = %d, %v, %v, want 0, 0, nil", moved, fraction, err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e", "f", "g"}
+	m, err := NewMaglev(nodes, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.MaxImbalance()
+	if err := m.Resize(10007); err != nil {
+		t.Fatal(err)
+	}
+	if m.Partitions() != 10007 {
+		t.Errorf("Partitions() = %d after Resize, want 10007", m.Partitions())
+	}
+	if got := m.Nodes(); fmt.Sprint(got) != fmt.Sprint(nodes) {
+		t.Errorf("Nodes() = %v after Resize, want %v", got, nodes)
+	}
+	if after := m.MaxImbalance(); after >= before {
+		t.Errorf("MaxImbalance() = %.4f after Resize, want less than %.4f", after, before)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []uint64{13, 10007, 10008} {
+		if err := m.Resize(n); err == nil {
+			t.Errorf("Resize(%d) of a ring with 10007 partitions succeeded", n)
+		}
+	}
+}
+
+func TestTooManyNodes(t *testing.T) {
+	_, err := NewMaglev([]string{"a", "b", "c", "d"}, 3, XXHasher{}, FNVHasher{})
+	if !errors.Is(err, ErrTooManyNodes) {
+		t.Errorf("NewMaglev with 4 nodes and 3 partitions returned %v, want ErrTooManyNodes", err)
+	}
+	m, err := NewMaglev([]string{"a", "b", "c"}, 3, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("d"); !errors.Is(err, ErrTooManyNodes) {
+		t.Errorf("Add beyond the number of partitions returned %v, want ErrTooManyNodes", err)
+	}
+}
+
+func TestNodesCopy(t *testing.T) {
+	m, err := NewMaglev([]string{"c", "a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := m.Nodes()
+	if fmt.Sprint(nodes) != "[a b c]" {
+		t.Fatalf("Nodes() = %v, want [a b c]", nodes)
+	}
+	nodes[0] = "z"
+	if !m.Contains("a") || m.Contains("z") || m.Nodes()[0] != "a" {
+		t.Error("modifying the result of Nodes changed the ring")
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAddRemoveAtomic(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 3, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("c", "d"); err == nil {
+		t.Error("Add beyond the number of partitions succeeded")
+	}
+	if _, err := m.Remove("a", "b"); !errors.Is(err, ErrEmptyRing) {
+		t.Errorf("Remove of all nodes returned %v, want ErrEmptyRing", err)
+	}
+	got, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("a failed Add or Remove changed the ring")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	m, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(m.Clone()) {
+		t.Error("Maglev differs from its clone")
+	}
+	same, err := NewMaglev([]string{"c", "b", "a"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(same) {
+		t.Error("Maglevs with the same nodes and hashers differ")
+	}
+	swapped, err := NewMaglev(nodes, 101, FNVHasher{}, XXHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Equal(swapped) {
+		t.Error("Maglevs with different hashers are equal")
+	}
+	if _, err := same.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Equal(same) {
+		t.Error("Maglevs with different nodes are equal")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	m, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+	for key := uint64(0); key < 100; key++ {
+		if node := m.Lookup(key); node != "" {
+			t.Fatalf("Lookup(%d) = %q after Drain, want \"\"", key, node)
+		}
+	}
+	if _, err := m.Add(nodes...); err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Error("adding the nodes back after Drain does not restore the routing")
+	}
+}
+
+func TestEmptyLookup(t *testing.T) {
+	m, err := NewMaglev(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node := m.Lookup(1); node != "" {
+		t.Errorf("Lookup on an empty ring = %q, want \"\"", node)
+	}
+	if node := m.LookupString("key"); node != "" {
+		t.Errorf("LookupString on an empty ring = %q, want \"\"", node)
+	}
+}
+
+// BenchmarkAddBatch adds 10 nodes one at a time, rebuilding the lookup table after every
+// node or only once at the end.
+func BenchmarkAddBatch(b *testing.B) {
+	var nodes []string
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	for _, deferred := range []bool{false, true} {
+		var opts []Option
+		name := "eager"
+		if deferred {
+			opts = append(opts, WithDeferredRebuild())
+			name = "deferred"
+		}
+		b.Run(name, func(b *testing.B) {
+			m, err := NewMaglev(nodes[:90], 65537, XXHasher{}, FNVHasher{}, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c := m.Clone()
+				for _, node := range nodes[90:] {
+					if _, err := c.Add(node); err != nil {
+						b.Fatal(err)
+					}
+				}
+				c.Rebuild()
+			}
+		})
+	}
+}
+
+func BenchmarkPopulateLookup(b *testing.B) {
+	var nodes []string
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	for _, numPartitions := range []uint64{10007, 100003, 1000003} {
+		b.Run(fmt.Sprint(numPartitions), func(b *testing.B) {
+			m, err := NewMaglev(nodes, numPartitions, XXHasher{}, FNVHasher{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s := *m.load()
+				s.populateLookup()
+			}
+		})
+	}
+}
+
+func TestLookupTableGolden(t *testing.T) {
+	// the lookup table must not change with the internal representation, or rings of
+	// different versions would route keys differently
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"c", "b", "a", "a", "a", "c", "c", "b", "b", "a", "b", "c", "a"}
+	table := m.LookupTable()
+	if fmt.Sprint(table) != fmt.Sprint(want) {
+		t.Errorf("LookupTable() = %q, want %q", table, want)
+	}
+	for key := uint64(0); key < 1000; key++ {
+		if got, want := m.Lookup(key), table[m.PartitionID(key)]; got != want {
+			t.Fatalf("Lookup(%d) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := m.LookupTable()
+	c := m.Clone()
+	if _, err := c.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.LookupTable(); fmt.Sprint(got) != fmt.Sprint(table) {
+		t.Error("changing a clone changed the lookup table of the original")
+	}
+	if m.Size() != 3 || !m.Contains("a") || m.Contains("d") {
+		t.Errorf("original has nodes %v after changing the clone", m.Nodes())
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPartitionOwner(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := m.LookupTable()
+	for partition := range table {
+		owner, err := m.PartitionOwner(partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner != table[partition] {
+			t.Errorf("PartitionOwner(%d) = %q, want %q", partition, owner, table[partition])
+		}
+	}
+	for _, partition := range []int{-1, 13, 14} {
+		if _, err := m.PartitionOwner(partition); err == nil {
+			t.Errorf("PartitionOwner(%d) succeeded", partition)
+		}
+	}
+	empty, err := NewMaglev(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.PartitionOwner(0); !errors.Is(err, ErrEmptyRing) {
+		t.Errorf("PartitionOwner on an empty ring returned %v, want ErrEmptyRing", err)
+	}
+}
+
+func TestPartitionsFor(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	m, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := make(map[int]string)
+	for _, node := range nodes {
+		for _, partition := range m.PartitionsFor(node) {
+			if other, ok := owners[partition]; ok {
+				t.Errorf("partition %d is owned by %q and %q", partition, other, node)
+			}
+			owners[partition] = node
+		}
+	}
+	if len(owners) != 101 {
+		t.Errorf("PartitionsFor covers %d partitions, want 101", len(owners))
+	}
+	if got := m.PartitionsFor("e"); got != nil {
+		t.Errorf("PartitionsFor of an unknown node = %v, want nil", got)
+	}
+}
+
+func TestNilHashers(t *testing.T) {
+	for _, tt := range []struct {
+		h1, h2 Hasher
+		opts   []Option
+	}{
+		{nil, FNVHasher{}, nil},
+		{XXHasher{}, nil, nil},
+		{nil, nil, nil},
+		{XXHasher{}, FNVHasher{}, []Option{WithKeyHasher(nil)}},
+	} {
+		if _, err := NewMaglev([]string{"a"}, 13, tt.h1, tt.h2, tt.opts...); err != errNilHasher {
+			t.Errorf("NewMaglev(%v, %v) returned %v, want %v", tt.h1, tt.h2, err, errNilHasher)
+		}
+	}
+}
+
+func TestLookupBytesAllocs(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("user-42")
+	if allocs := testing.AllocsPerRun(100, func() { m.LookupBytes(key) }); allocs != 0 {
+		t.Errorf("LookupBytes allocates %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkLookupBytes(b *testing.B) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	key := []byte("user-42")
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.LookupBytes(key)
+		}
+	})
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.LookupString(string(key))
+		}
+	})
+}
+
+func TestLookupTableCopy(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := m.LookupTable()
+	if len(table) != 101 {
+		t.Fatalf("LookupTable() has %d elements, want 101", len(table))
+	}
+	owner := table[0]
+	table[0] = "z"
+	if got, _ := m.PartitionOwner(0); got != owner {
+		t.Errorf("modifying the result of LookupTable changed the owner of partition 0 to %q", got)
+	}
+}
+
+func TestOrderIndependent(t *testing.T) {
+	byLength := func(a, b string) bool { return len(a) < len(b) }
+	for _, opts := range [][]Option{nil, {WithHashOrder()}, {WithNodeOrder(byLength)}} {
+		want, err := NewMaglev([]string{"a", "bb", "ccc", "dddd"}, 101, XXHasher{}, FNVHasher{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewMaglev([]string{"dddd", "e"}, 101, XXHasher{}, FNVHasher{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Add("bb", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Remove("e"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Add("ccc"); err != nil {
+			t.Fatal(err)
+		}
+		if !m.Equal(want) {
+			t.Errorf("%d options: building the same nodes by Add and Remove gives a different table", len(opts))
+		}
+	}
+}
+
+func TestChurnDeterministic(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	want, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]func(m *Maglev) error{
+		"duplicates": func(m *Maglev) error {
+			_, err := m.Add("a", "a", "b", "c", "d", "d")
+			return err
+		},
+		"one by one": func(m *Maglev) error {
+			for _, node := range []string{"d", "c", "b", "a"} {
+				if _, err := m.Add(node); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"churn": func(m *Maglev) error {
+			if _, err := m.Add("x", "a", "y", "b"); err != nil {
+				return err
+			}
+			if _, err := m.Remove("x"); err != nil {
+				return err
+			}
+			if _, err := m.Add("c", "d"); err != nil {
+				return err
+			}
+			_, err := m.Remove("y")
+			return err
+		},
+		"reconcile": func(m *Maglev) error {
+			if err := m.Reconcile([]string{"e", "f", "a"}); err != nil {
+				return err
+			}
+			return m.Reconcile([]string{"d", "c", "b", "a", "a"})
+		},
+	}
+	for name, path := range paths {
+		m, err := NewMaglev(nil, 101, XXHasher{}, FNVHasher{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := path(m); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, wantData) {
+			t.Errorf("%s: lookup table differs from a ring created with the same nodes", name)
+		}
+	}
+	dup, err := NewMaglev([]string{"a", "b", "a", "c", "d", "b"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dup.Equal(want) {
+		t.Error("NewMaglev with duplicate nodes differs from one without")
+	}
+}
+
+func TestLookupWithSalt(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d", "e"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	differ := 0
+	for key := uint64(0); key < 1000; key++ {
+		if got, want := m.LookupWithSalt(key, 0), m.Lookup(key); got != want {
+			t.Fatalf("LookupWithSalt(%d, 0) = %q, want Lookup's %q", key, got, want)
+		}
+		a, b := m.LookupWithSalt(key, 1), m.LookupWithSalt(key, 2)
+		if a != m.LookupWithSalt(key, 1) {
+			t.Fatalf("LookupWithSalt(%d, 1) is not reproducible", key)
+		}
+		if a != b {
+			differ++
+		}
+	}
+	// with 5 nodes, about 4 in 5 keys map to different nodes under independent salts
+	if differ < 600 {
+		t.Errorf("only %d of 1000 keys map to different nodes under two salts", differ)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		desired         []string
+		toAdd, toRemove string
+	}{
+		{"unchanged", []string{"c", "a", "b"}, "[]", "[]"},
+		{"additions only", []string{"a", "b", "c", "e", "d", "d"}, "[d e]", "[]"},
+		{"removals only", []string{"b"}, "[]", "[a c]"},
+		{"mixed", []string{"c", "x", "a"}, "[x]", "[b]"},
+	}
+	for _, tt := range tests {
+		toAdd, toRemove := m.Diff(tt.desired)
+		if fmt.Sprint(toAdd) != tt.toAdd || fmt.Sprint(toRemove) != tt.toRemove {
+			t.Errorf("%s: Diff(%v) = %v, %v, want %s, %s", tt.name, tt.desired, toAdd, toRemove, tt.toAdd, tt.toRemove)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reconcile([]string{"x", "y"}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewMaglev([]string{"x", "y"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Errorf("Reconcile to a different node set gives nodes %v and a different table", m.Nodes())
+	}
+
+	gen := m.Generation()
+	if err := m.Reconcile(nil); !errors.Is(err, ErrEmptyRing) {
+		t.Errorf("Reconcile(nil) returned %v, want ErrEmptyRing", err)
+	}
+	if err := m.Reconcile([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14"}); !errors.Is(err, ErrTooManyNodes) {
+		t.Errorf("Reconcile to more nodes than partitions returned %v, want ErrTooManyNodes", err)
+	}
+	if m.Generation() != gen || !m.Equal(want) {
+		t.Error("a failed Reconcile changed the ring")
+	}
+}
+
+func TestLookupU128(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := new(big.Int).SetUint64(m.Partitions())
+	values := []uint64{0, 1, 2, 1008, 1009, 1 << 32, 1<<63 + 5, math.MaxUint64}
+	for _, hi := range values {
+		for _, lo := range values {
+			key := new(big.Int).Lsh(new(big.Int).SetUint64(hi), 64)
+			key.Or(key, new(big.Int).SetUint64(lo))
+			partition := key.Mod(key, n).Int64()
+			want, err := m.PartitionOwner(int(partition))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.LookupU128(hi, lo); got != want {
+				t.Errorf("LookupU128(%d, %d) = %q, want owner %q of partition %d", hi, lo, got, want, partition)
+			}
+		}
+	}
+}
+
+func TestAddTracked(t *testing.T) {
+	var nodes []string
+	for i := 0; i < 9; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	m, err := NewMaglev(nodes, 65537, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.LookupTable()
+	added, changed, err := m.AddTracked("node-9")
+	if err != nil || added != 1 {
+		t.Fatalf("AddTracked = %d, %v", added, err)
+	}
+	if ideal := 65537 / 10; len(changed) < ideal || len(changed) > ideal*11/10 {
+		t.Errorf("AddTracked changed %d partitions, want about %d", len(changed), ideal)
+	}
+	checkChanged(t, before, m.LookupTable(), changed)
+
+	before = m.LookupTable()
+	removed, changed, err := m.RemoveTracked("node-3")
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveTracked = %d, %v", removed, err)
+	}
+	checkChanged(t, before, m.LookupTable(), changed)
+}
+
+// checkChanged fails t unless changed are the sorted ids of the partitions that differ between
+// the lookup tables before and after.
+func checkChanged(t *testing.T, before, after []string, changed []int) {
+	t.Helper()
+	var want []int
+	for partition := range after {
+		if before[partition] != after[partition] {
+			want = append(want, partition)
+		}
+	}
+	if fmt.Sprint(changed) != fmt.Sprint(want) {
+		t.Errorf("%d partitions reported as changed, want %d", len(changed), len(want))
+	}
+}
+
+func TestRange(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := m.LookupTable()
+	calls := 0
+	m.Range(func(partition int, node string) bool {
+		if partition != calls || node != table[partition] {
+			t.Errorf("Range call %d got partition %d owned by %q, want %d owned by %q",
+				calls, partition, node, calls, table[calls])
+		}
+		calls++
+		return true
+	})
+	if calls != 101 {
+		t.Errorf("Range called fn %d times, want 101", calls)
+	}
+	calls = 0
+	m.Range(func(int, string) bool {
+		calls++
+		return calls < 5
+	})
+	if calls != 5 {
+		t.Errorf("Range called fn %d times after it returned false, want 5", calls)
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e"}
+	// replicas do not tighten the balance, which is within one partition per node either way
+	bound := 1 + float64(len(nodes))/1009
+	for _, replicas := range []int{1, 8} {
+		m, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{}, WithReplicas(replicas))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.MaxImbalance(); got > bound {
+			t.Errorf("%d replicas: MaxImbalance() = %.4f, want at most %.4f", replicas, got, bound)
+		}
+		for node := range m.Distribution() {
+			if !m.Contains(node) {
+				t.Errorf("%d replicas: partitions are owned by %q, which is not a node", replicas, node)
+			}
+		}
+	}
+}
+
+func TestPreferenceList(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e"}
+	m, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := 0
+	for partition := 0; partition < 1009; partition++ {
+		prefs := m.PreferenceList(partition)
+		if len(prefs) != len(nodes) {
+			t.Fatalf("PreferenceList(%d) = %v, want all nodes", partition, prefs)
+		}
+		if owner, _ := m.PartitionOwner(partition); prefs[0] == owner {
+			first++
+		}
+	}
+	// the owner is only preceded by nodes that were blocked by partitions claimed earlier
+	if first < 1009*9/10 {
+		t.Errorf("the owner is first in the preference list of only %d of 1009 partitions", first)
+	}
+	for _, partition := range []int{-1, 1009} {
+		if prefs := m.PreferenceList(partition); prefs != nil {
+			t.Errorf("PreferenceList(%d) = %v, want nil", partition, prefs)
+		}
+	}
+}
+
+func TestLookupHealthy(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d", "e"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := map[string]bool{"b": true, "d": true}
+	healthy := func(node string) bool { return !down[node] }
+	for key := uint64(0); key < 10000; key++ {
+		owner, got := m.Lookup(key), m.LookupHealthy(key, healthy)
+		if !down[owner] && got != owner {
+			t.Fatalf("LookupHealthy(%d) = %q, but its owner %q is healthy", key, got, owner)
+		}
+		if down[got] || got == "" {
+			t.Fatalf("LookupHealthy(%d) = %q, want a healthy node", key, got)
+		}
+		if down[owner] && got != m.LookupHealthy(key, healthy) {
+			t.Fatalf("LookupHealthy(%d) fails over inconsistently", key)
+		}
+	}
+	if got := m.LookupHealthy(1, func(string) bool { return false }); got != "" {
+		t.Errorf("LookupHealthy without healthy nodes = %q, want \"\"", got)
+	}
+	if got, want := m.LookupHealthy(1, nil), m.Lookup(1); got != want {
+		t.Errorf("LookupHealthy(1, nil) = %q, want %q", got, want)
+	}
+}
+
+// TestConcurrentSnapshots is meant to be run with -race. Every read sees a complete
+// snapshot, so readers never observe a node that is not in some published state.
+func TestConcurrentSnapshots(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := map[string]bool{"a": true, "b": true, "c": true, "d": true, "e": true}
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			for key := uint64(i); ; key++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if node := m.Lookup(key); !known[node] {
+					t.Errorf("Lookup(%d) = %q", key, node)
+					return
+				}
+				for _, node := range m.LookupN(key, 3) {
+					if !known[node] {
+						t.Errorf("LookupN(%d) returned %q", key, node)
+						return
+					}
+				}
+				if key%64 == 0 {
+					if err := m.Validate(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	var writers sync.WaitGroup
+	for _, node := range []string{"c", "d", "e"} {
+		writers.Add(1)
+		go func(node string) {
+			defer writers.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := m.Add(node); err != nil {
+					t.Error(err)
+				}
+				if _, err := m.Remove(node); err != nil {
+					t.Error(err)
+				}
+			}
+		}(node)
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	if got := m.Nodes(); fmt.Sprint(got) != "[a b]" {
+		t.Errorf("Nodes() = %v after the writers finished, want [a b]", got)
+	}
+}
+
+func TestRemoveFunc(t *testing.T) {
+	m, err := NewMaglev([]string{"eu-1", "eu-2", "us-1", "us-2"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := m.RemoveFunc(func(node string) bool { return strings.HasPrefix(node, "eu-") })
+	if err != nil || removed != 2 {
+		t.Fatalf("RemoveFunc = %d, %v, want 2", removed, err)
+	}
+	if got := m.Nodes(); fmt.Sprint(got) != "[us-1 us-2]" {
+		t.Errorf("Nodes() = %v after RemoveFunc, want [us-1 us-2]", got)
+	}
+	if _, err := m.RemoveFunc(func(string) bool { return true }); !errors.Is(err, ErrEmptyRing) {
+		t.Errorf("RemoveFunc of all nodes returned %v, want ErrEmptyRing", err)
+	}
+	if m.Size() != 2 {
+		t.Errorf("Size() = %d after a failed RemoveFunc, want 2", m.Size())
+	}
+}
+
+// BenchmarkRebuild measures the allocations of a rebuild, which reuses pooled scratch buffers.
+func BenchmarkRebuild(b *testing.B) {
+	var nodes []string
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	m, err := NewMaglev(nodes, 65537, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// alternate between two node sets, so every Rebuild has work to do
+		if i%2 == 0 {
+			_, err = m.Remove(nodes[0])
+		} else {
+			_, err = m.Add(nodes[0])
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Rebuild()
+	}
+}
+
+func TestWouldMove(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := m.Clone()
+	if _, err := after.Add("e"); err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 2000; key++ {
+		if got, want := m.WouldMove(key, "e"), m.Lookup(key) != after.Lookup(key); got != want {
+			t.Fatalf("WouldMove(%d, \"e\") = %v, but adding e moves it: %v", key, got, want)
+		}
+	}
+	if m.WouldMove(1, "a") {
+		t.Error("WouldMove of a present node = true")
+	}
+	if m.Size() != 4 {
+		t.Errorf("WouldMove changed the ring to %v", m.Nodes())
+	}
+}
+
+func TestDuplicateNodes(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 2 || !m.Equal(want) {
+		t.Errorf("ring of {a, a, b} has nodes %v and differs from {a, b}", m.Nodes())
+	}
+	if _, err := NewMaglev([]string{"a", "a", "b"}, 13, XXHasher{}, FNVHasher{}, WithStrictValidation()); err == nil {
+		t.Error("NewMaglev with duplicate nodes and strict validation succeeded")
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	for _, numNodes := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprint(numNodes), func(b *testing.B) {
+			var nodes []string
+			for i := 0; i < numNodes; i++ {
+				nodes = append(nodes, fmt.Sprintf("node-%d", i))
+			}
+			m, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Contains(nodes[i%numNodes])
+			}
+		})
+	}
+}
+
+func TestEmptyNodeName(t *testing.T) {
+	m, err := NewMaglev([]string{"", "a", "b"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Contains("") || m.Size() != 3 {
+		t.Fatalf("ring has nodes %q, want the empty node too", m.Nodes())
+	}
+	if len(m.PartitionsFor("")) == 0 {
+		t.Error("the empty node owns no partitions")
+	}
+	for key := uint64(0); key < 1000; key++ {
+		owner, err := m.PartitionOwner(m.PartitionID(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Lookup(key); got != owner {
+			t.Fatalf("Lookup(%d) = %q, want %q", key, got, owner)
+		}
+		if got := m.LookupHealthy(key, func(string) bool { return true }); got != owner {
+			t.Fatalf("LookupHealthy(%d) = %q, want %q", key, got, owner)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLookupAll(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []uint64{0, 1, 2, 100, 101, 1 << 40}
+	nodes := m.LookupAll(keys)
+	dst := make([]string, len(keys))
+	if err := m.LookupAllInto(keys, dst); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if nodes[i] != m.Lookup(key) || dst[i] != nodes[i] {
+			t.Errorf("key %d: LookupAll = %q, LookupAllInto = %q, want %q", key, nodes[i], dst[i], m.Lookup(key))
+		}
+	}
+	if err := m.LookupAllInto(keys, dst[1:]); err == nil {
+		t.Error("LookupAllInto with a short destination succeeded")
+	}
+}
+
+func BenchmarkLookupAll(b *testing.B) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 65537, XXHasher{}, FNVHasher{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := make([]uint64, 1024)
+	for i := range keys {
+		keys[i] = uint64(i) * 0x9e3779b97f4a7c15
+	}
+	dst := make([]string, len(keys))
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = m.LookupAllInto(keys, dst)
+		}
+	})
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j, key := range keys {
+				dst[j] = m.Lookup(key)
+			}
+		}
+	})
+}
+
+func TestGeneration(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := m.Generation()
+	m.Lookup(1)
+	m.LookupN(1, 2)
+	m.Nodes()
+	m.Stats()
+	_ = m.String()
+	if _, err := m.Remove("x"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Generation() != gen {
+		t.Errorf("read-only calls changed the generation from %d to %d", gen, m.Generation())
+	}
+	if _, err := m.Add("c"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Generation() != gen+1 {
+		t.Errorf("Generation() = %d after Add, want %d", m.Generation(), gen+1)
+	}
+	if _, err := m.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Generation() != gen+2 {
+		t.Errorf("Generation() = %d after Remove, want %d", m.Generation(), gen+2)
+	}
+}
+
+func TestSmallPartitionCounts(t *testing.T) {
+	for _, numPartitions := range []uint64{2, 3, 5} {
+		for numNodes := 1; uint64(numNodes) <= numPartitions; numNodes++ {
+			var nodes []string
+			for i := 0; i < numNodes; i++ {
+				nodes = append(nodes, fmt.Sprintf("node-%d", i))
+			}
+			m, err := NewMaglev(nodes, numPartitions, XXHasher{}, FNVHasher{})
+			if err != nil {
+				t.Fatalf("%d nodes, %d partitions: %v", numNodes, numPartitions, err)
+			}
+			if err := m.Validate(); err != nil {
+				t.Errorf("%d nodes, %d partitions: %v", numNodes, numPartitions, err)
+			}
+			for _, node := range nodes {
+				if len(m.PartitionsFor(node)) == 0 {
+					t.Errorf("%d nodes, %d partitions: node %q owns no partitions", numNodes, numPartitions, node)
+				}
+			}
+		}
+	}
+	for _, numPartitions := range []uint64{0, 1, 4} {
+		if _, err := NewMaglev([]string{"a"}, numPartitions, XXHasher{}, FNVHasher{}); !errors.Is(err, ErrNotPrime) {
+			t.Errorf("NewMaglev with %d partitions returned %v, want ErrNotPrime", numPartitions, err)
+		}
+	}
+}
+
+func TestPermutationsArePermutations(t *testing.T) {
+	for _, numPartitions := range []uint64{2, 3, 13, 1009} {
+		m, err := NewMaglev(nil, numPartitions, XXHasher{}, FNVHasher{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := m.load()
+		for i := 0; i < 50; i++ {
+			permutation := s.generatePermutation(fmt.Sprintf("node-%d", i))
+			if uint64(len(permutation)) != numPartitions {
+				t.Fatalf("permutation has %d elements, want %d", len(permutation), numPartitions)
+			}
+			seen := make([]bool, numPartitions)
+			for _, partition := range permutation {
+				if partition >= numPartitions || seen[partition] {
+					t.Fatalf("permutation of node-%d over %d partitions repeats or exceeds %d",
+						i, numPartitions, partition)
+				}
+				seen[partition] = true
+			}
+		}
+	}
+}
+
+func TestLookupCanary(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadow, err := NewMaglev([]string{"canary"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numKeys = 100000
+	prev := make([]bool, numKeys)
+	for _, fraction := range []float64{0, 0.01, 0.1, 0.5, 1} {
+		n := 0
+		for key := uint64(0); key < numKeys; key++ {
+			node, isShadow := m.LookupCanary(key, shadow, fraction)
+			if isShadow != (node == "canary") {
+				t.Fatalf("LookupCanary(%d) = %q, %v", key, node, isShadow)
+			}
+			if !isShadow && node != m.Lookup(key) {
+				t.Fatalf("LookupCanary(%d) = %q, want %q", key, node, m.Lookup(key))
+			}
+			if prev[key] && !isShadow {
+				t.Fatalf("key %d left the shadow when the fraction grew to %v", key, fraction)
+			}
+			prev[key] = isShadow
+			if isShadow {
+				n++
+			}
+		}
+		if got := float64(n) / numKeys; got < fraction-0.01 || got > fraction+0.01 {
+			t.Errorf("fraction %v routed %.4f of the keys to the shadow", fraction, got)
+		}
+	}
+	if _, isShadow := m.LookupCanary(1, nil, 1); isShadow {
+		t.Error("LookupCanary without a shadow routed to it")
+	}
+}
+
+func TestDeferredRebuild(t *testing.T) {
+	eager, err := NewMaglev([]string{"a", "b"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deferred, err := NewMaglev([]string{"a", "b"}, 101, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := deferred.LookupTable()
+	for _, m := range []*Maglev{eager, deferred} {
+		if _, err := m.Add("c", "d", "e"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Remove("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !deferred.Stale() || deferred.Size() != 4 {
+		t.Fatalf("deferred ring has %d nodes, stale %v, want 4 and stale", deferred.Size(), deferred.Stale())
+	}
+	// lookups keep using the old table until Rebuild
+	if got := deferred.LookupTable(); fmt.Sprint(got) != fmt.Sprint(table) {
+		t.Error("lookup table changed before Rebuild")
+	}
+	if _, err := deferred.PartitionOwner(0); !errors.Is(err, ErrStale) {
+		t.Errorf("PartitionOwner of a stale ring returned %v, want ErrStale", err)
+	}
+	deferred.Rebuild()
+	if deferred.Stale() || !deferred.Equal(eager) {
+		t.Error("deferred ring differs from the eager one after Rebuild")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	m, err := NewWeightedMaglev(map[string]uint64{"a": 2, "b": 1}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewWeightedMaglev(map[string]uint64{"b": 5, "c": 3}, 101, FNVHasher{}, XXHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := m.Merge(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewWeightedMaglev(map[string]uint64{"a": 2, "b": 1, "c": 3}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Equal(want) {
+		t.Errorf("merged ring with nodes %v routes differently than one created with them", merged.Nodes())
+	}
+	if m.Size() != 2 || other.Size() != 2 {
+		t.Error("Merge changed its inputs")
+	}
+
+	small, err := NewMaglev([]string{"x"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Merge(small); err == nil {
+		t.Error("Merge of rings with different numbers of partitions succeeded")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 2, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errNotPrime := NewMaglev([]string{"a"}, 4, XXHasher{}, FNVHasher{})
+	_, errTooMany := m.Add("c")
+	_, errEmpty := m.Remove("a", "b")
+	errNotFound := m.Reweight("c", 2)
+	if _, err := m.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	_, errStale := m.PartitionOwner(0)
+	tests := []struct {
+		err, want error
+	}{
+		{errNotPrime, ErrNotPrime},
+		{errTooMany, ErrTooManyNodes},
+		{errEmpty, ErrEmptyRing},
+		{errEmpty, ErrEmpty},
+		{errNotFound, ErrNodeNotFound},
+		{errStale, ErrStale},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("got %v, want %v", tt.err, tt.want)
+		}
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var m Maglev
+	if m.Size() != 0 || m.Partitions() != 0 || m.Generation() != 0 {
+		t.Errorf("zero value has %d nodes, %d partitions, generation %d", m.Size(), m.Partitions(), m.Generation())
+	}
+	if m.Lookup(1) != "" || m.LookupString("key") != "" || m.LookupN(1, 2) != nil {
+		t.Error("zero value routes keys")
+	}
+	if m.Contains("a") || len(m.Nodes()) != 0 || m.PartitionID(1) != 0 {
+		t.Error("zero value has nodes or partitions")
+	}
+	if _, err := m.PartitionOwner(0); err == nil {
+		t.Error("PartitionOwner on the zero value succeeded")
+	}
+	if _, err := m.Add("a"); err == nil {
+		t.Error("Add on the zero value succeeded")
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+	m.Rebuild()
+	m.Drain()
+	_ = m.String()
+	m.Stats()
+	m.Distribution()
+	m.Fingerprint()
+	if !m.Equal(m.Clone()) {
+		t.Error("zero value differs from its clone")
+	}
+}
+
+func TestPermutation(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	permutation, ok := m.Permutation("b")
+	if !ok {
+		t.Fatal("Permutation of a present node failed")
+	}
+	seen := make(map[uint64]bool)
+	for _, partition := range permutation {
+		if partition >= 101 || seen[partition] {
+			t.Fatalf("Permutation(\"b\") is not a permutation of [0, 101): %v", permutation)
+		}
+		seen[partition] = true
+	}
+	if len(seen) != 101 {
+		t.Fatalf("Permutation(\"b\") has %d partitions, want 101", len(seen))
+	}
+	permutation[0], permutation[1] = permutation[1], permutation[0]
+	if again, _ := m.Permutation("b"); again[0] == permutation[0] {
+		t.Error("modifying the result of Permutation changed the permutation")
+	}
+	if _, ok := m.Permutation("d"); ok {
+		t.Error("Permutation of an unknown node succeeded")
+	}
+}
+
+func TestBlend(t *testing.T) {
+	old, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := NewMaglev([]string{"d", "e", "f"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numKeys = 100000
+	onNew := make([]bool, numKeys)
+	for _, ratio := range []float64{0, 0.1, 0.25, 0.5, 0.75, 0.9, 1} {
+		moved := 0
+		for key := uint64(0); key < numKeys; key++ {
+			node := Blend(old, new, key, ratio)
+			isNew := new.Contains(node)
+			if !isNew && node != old.Lookup(key) || isNew && node != new.Lookup(key) {
+				t.Fatalf("Blend(%d, %v) = %q, neither the old nor the new node", key, ratio, node)
+			}
+			if onNew[key] && !isNew {
+				t.Fatalf("key %d moved back to the old ring at ratio %v", key, ratio)
+			}
+			onNew[key] = isNew
+			if isNew {
+				moved++
+			}
+		}
+		if got := float64(moved) / numKeys; math.Abs(got-ratio) > 0.01 {
+			t.Errorf("at ratio %v, %.3f of the keys are routed with the new ring", ratio, got)
+		}
+	}
+
+	empty, err := NewMaglev(nil, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Blend(old, empty, 1, 1), old.Lookup(1); got != want {
+		t.Errorf("Blend to an empty ring = %q, want %q", got, want)
+	}
+	if got, want := Blend(empty, new, 1, 0), new.Lookup(1); got != want {
+		t.Errorf("Blend from an empty ring = %q, want %q", got, want)
+	}
+}
+
+func TestLookupIndex(t *testing.T) {
+	byLength := func(a, b string) bool { return len(a) < len(b) || len(a) == len(b) && a < b }
+	for _, opts := range [][]Option{nil, {WithHashOrder()}, {WithNodeOrder(byLength)}} {
+		m, err := NewMaglev([]string{"a", "bb", "c", "ddd", "e"}, 101, XXHasher{}, FNVHasher{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes := m.Nodes()
+		for key := uint64(0); key < 1000; key++ {
+			if got, want := nodes[m.LookupIndex(key)], m.Lookup(key); got != want {
+				t.Fatalf("Nodes()[LookupIndex(%d)] = %q, want %q", key, got, want)
+			}
+		}
+	}
+
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	nodes := m.Nodes()
+	for key := uint64(0); key < 1000; key++ {
+		idx := m.LookupIndex(key)
+		if owner := m.Lookup(key); owner == "a" {
+			if idx != -1 {
+				t.Fatalf("LookupIndex(%d) = %d for a key of a removed node, want -1", key, idx)
+			}
+		} else if nodes[idx] != owner {
+			t.Fatalf("Nodes()[LookupIndex(%d)] = %q, want %q", key, nodes[idx], owner)
+		}
+	}
+
+	empty, err := NewMaglev(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := empty.LookupIndex(1); got != -1 {
+		t.Errorf("LookupIndex on a Maglev without nodes = %d, want -1", got)
+	}
+}
+
+// hasherFunc is a Hasher that hashes the result of a function of the string with h.
+type hasherFunc struct {
+	h  Hasher
+	fn func(s string) string
+}
+
+func (h hasherFunc) Hash(s string) uint64 { return h.h.Hash(h.fn(s)) }
+
+func TestHashOrderRename(t *testing.T) {
+	// hosts are identified by their number, so leading zeros do not change their hashes
+	number := func(s string) string { return strings.TrimLeft(strings.TrimPrefix(s, "host-"), "0") }
+	h1, h2 := hasherFunc{XXHasher{}, number}, hasherFunc{FNVHasher{}, number}
+	var nodes []string
+	for i := 1; i <= 12; i++ {
+		nodes = append(nodes, fmt.Sprintf("host-%d", i))
+	}
+	renamed := append([]string{"host-09"}, nodes[:8]...)
+	renamed = append(renamed, nodes[9:]...)
+
+	moved := func(opts ...Option) int {
+		t.Helper()
+		m, err := NewMaglev(nodes, 1009, h1, h2, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewMaglev(renamed, 1009, h1, h2, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved := 0
+		for partition := 0; partition < 1009; partition++ {
+			before, _ := m.PartitionOwner(partition)
+			after, _ := r.PartitionOwner(partition)
+			if before == "host-9" {
+				before = "host-09"
+			}
+			if before != after {
+				moved++
+			}
+		}
+		return moved
+	}
+	if got := moved(WithHashOrder()); got != 0 {
+		t.Errorf("with hash order, renaming host-9 to host-09 moved %d partitions", got)
+	}
+	if got := moved(); got == 0 {
+		t.Error("with name order, renaming host-9 to host-09 moved no partitions")
+	}
+}
+
+func TestRemoveTo(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d", "e"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.LookupTable()
+	if err := m.RemoveTo("b", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Contains("b") {
+		t.Error("RemoveTo did not remove the node")
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for partition, after := range m.LookupTable() {
+		want := before[partition]
+		if want == "b" {
+			want = "e"
+		}
+		if after != want {
+			t.Errorf("partition %d moved from %q to %q, want %q", partition, before[partition], after, want)
+		}
+	}
+
+	for _, tt := range []struct{ node, successor string }{{"b", "a"}, {"a", "b"}, {"a", "a"}} {
+		if err := m.RemoveTo(tt.node, tt.successor); err == nil || tt.node != tt.successor && !errors.Is(err, ErrNodeNotFound) {
+			t.Errorf("RemoveTo(%q, %q) = %v", tt.node, tt.successor, err)
+		}
+	}
+	if !m.Contains("a") {
+		t.Error("failed RemoveTo removed a node")
+	}
+
+	deferred, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{}, WithDeferredRebuild())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deferred.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deferred.RemoveTo("a", "b"); !errors.Is(err, ErrStale) {
+		t.Errorf("RemoveTo on a stale Maglev = %v, want ErrStale", err)
+	}
+}
+
+func TestParallelPermutations(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var nodes []string
+	for i := 0; i < 20; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	s := &state{config: &config{h1: XXHasher{}, h2: FNVHasher{}}, nodes: nodes, numPartitions: 65537}
+	if uint64(len(nodes))*s.numPartitions < parallelPermutationsMin {
+		t.Fatal("ring too small to generate its permutations in parallel")
+	}
+	s.generatePermutations()
+	for i, node := range nodes {
+		if !reflect.DeepEqual(s.permutations[i], s.generatePermutation(node)) {
+			t.Fatalf("permutation of %q differs from the sequentially generated one", node)
+		}
+	}
+
+	parallel, err := NewMaglev(nodes, 65537, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GOMAXPROCS(1)
+	sequential, err := NewMaglev(nodes, 65537, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parallel.Equal(sequential) {
+		t.Error("lookup tables built from parallel and sequential permutations differ")
+	}
+}
+
+// BenchmarkGeneratePermutations compares generating the permutations of a large ring on one
+// and on all CPUs.
+func BenchmarkGeneratePermutations(b *testing.B) {
+	var nodes []string
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	s := &state{config: &config{h1: XXHasher{}, h2: FNVHasher{}}, nodes: nodes, numPartitions: 65537}
+	procs := []int{1}
+	if runtime.NumCPU() > 1 {
+		procs = append(procs, runtime.NumCPU())
+	}
+	for _, procs := range procs {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			for i := 0; i < b.N; i++ {
+				s.generatePermutations()
+			}
+		})
+	}
+}
+
+func TestRemovalImpact(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d", "e"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impact := m.RemovalImpact("c")
+	if got, want := len(impact), len(m.PartitionsFor("c")); got != want {
+		t.Errorf("RemovalImpact(\"c\") has %d partitions, want the %d partitions of c", got, want)
+	}
+	if m.Generation() != 0 || !m.Contains("c") {
+		t.Fatal("RemovalImpact changed Maglev")
+	}
+	if _, err := m.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	for partition, successor := range impact {
+		if owner, _ := m.PartitionOwner(partition); owner != successor {
+			t.Errorf("partition %d went to %q after Remove, RemovalImpact predicted %q", partition, owner, successor)
+		}
+	}
+
+	if impact := m.RemovalImpact("c"); impact != nil {
+		t.Errorf("RemovalImpact of an unknown node = %v, want nil", impact)
+	}
+	single, err := NewMaglev([]string{"a"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if impact := single.RemovalImpact("a"); impact != nil {
+		t.Errorf("RemovalImpact of the only node = %v, want nil", impact)
+	}
+}
+
+func TestNodeOrder(t *testing.T) {
+	port := func(node string) int {
+		var p int
+		fmt.Sscanf(node[strings.LastIndexByte(node, ':')+1:], "%d", &p)
+		return p
+	}
+	byPort := func(a, b string) bool { return port(a) < port(b) }
+	nodes := []string{"10.0.0.1:100", "10.0.0.1:80", "10.0.0.2:9", "10.0.0.1:9", "10.0.0.1:8080"}
+
+	m, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{}, WithNodeOrder(byPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nodes with equal ports are ordered by name
+	want := []string{"10.0.0.1:9", "10.0.0.2:9", "10.0.0.1:80", "10.0.0.1:100", "10.0.0.1:8080"}
+	if got := m.Nodes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes() = %v, want %v", got, want)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	reversed := make([]string, len(nodes))
+	for i, node := range nodes {
+		reversed[len(nodes)-1-i] = node
+	}
+	again, err := NewMaglev(reversed, 101, XXHasher{}, FNVHasher{}, WithNodeOrder(byPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(again) {
+		t.Error("the lookup table depends on the order of the input nodes")
+	}
+
+	byName, err := NewMaglev(nodes, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(byName.Nodes(), m.Nodes()) {
+		t.Error("the custom order did not change the order of the nodes")
+	}
+	if moved, _, _ := Disruption(byName, m); moved == 0 {
+		t.Error("the custom order did not change the lookup table")
+	}
+}
+
+func TestLookupReplicas(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d", "e"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numKeys = 20000
+	second := make(map[string]int)
+	for key := uint64(0); key < numKeys; key++ {
+		replicas := m.LookupReplicas(key, 3, nil)
+		if len(replicas) != 3 || replicas[0] != m.Lookup(key) {
+			t.Fatalf("LookupReplicas(%d, 3) = %v, want 3 nodes starting with %q", key, replicas, m.Lookup(key))
+		}
+		if replicas[1] == replicas[0] || replicas[2] == replicas[0] || replicas[1] == replicas[2] {
+			t.Fatalf("LookupReplicas(%d, 3) = %v has duplicates", key, replicas)
+		}
+		if again := m.LookupReplicas(key, 3, nil); !reflect.DeepEqual(again, replicas) {
+			t.Fatalf("LookupReplicas(%d, 3) = %v, then %v", key, replicas, again)
+		}
+		second[replicas[1]]++
+	}
+	for _, node := range m.Nodes() {
+		if got := second[node]; got < numKeys/5*9/10 || got > numKeys/5*11/10 {
+			t.Errorf("node %q is the first replica of %d keys, want about %d", node, got, numKeys/5)
+		}
+	}
+
+	rng := func(seed uint64) uint64 { return splitmix64(seed, 1) }
+	if got := m.LookupReplicas(1, 5, rng); len(got) != 5 || got[0] != m.Lookup(1) {
+		t.Errorf("LookupReplicas(1, 5) with a custom rng = %v", got)
+	}
+	if got := m.LookupReplicas(1, 10, nil); len(got) != 5 {
+		t.Errorf("LookupReplicas(1, 10) = %v, want all 5 nodes", got)
+	}
+	if got := m.LookupReplicas(1, 0, nil); got != nil {
+		t.Errorf("LookupReplicas(1, 0) = %v, want nil", got)
+	}
+}
+
+func TestPartitionIDString(t *testing.T) {
+	for _, h := range []Hasher{nil, FNVHasher{}, SeededHasher{Seed: 7}} {
+		var opts []Option
+		want := Hasher(XXHasher{})
+		if h != nil {
+			opts = append(opts, WithKeyHasher(h))
+			want = h
+		}
+		m, err := NewMaglev([]string{"a", "b", "c", "d"}, 101, XXHasher{}, FNVHasher{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			partition := m.PartitionIDString(key)
+			if want := m.PartitionID(want.Hash(key)); partition != want {
+				t.Fatalf("PartitionIDString(%q) = %d, want %d", key, partition, want)
+			}
+			if owner, err := m.PartitionOwner(partition); err != nil || owner != m.LookupString(key) {
+				t.Fatalf("PartitionOwner(PartitionIDString(%q)) = %q, %v, want %q", key, owner, err, m.LookupString(key))
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package maglevtest
+
+import (
+	"testing"
+
+	"maglev"
+)
+
+// AssertLookupConsistent checks that Lookup routes keys to the owner of their partition, i.e.
+// that m.Lookup(key) equals m.PartitionOwner(m.PartitionID(key)), and fails t for every key
+// where they differ. It samples numSampleKeys keys spread evenly over [0, Partitions()) as
+// well as numSampleKeys keys of Keys, so that every partition is covered if numSampleKeys is
+// at least the number of partitions. It is meant for the tests of packages that wrap maglev as
+// much as for maglev itself. m must not have pending changes, see maglev.WithDeferredRebuild.
+func AssertLookupConsistent(t testing.TB, m *maglev.Maglev, numSampleKeys int) {
+	t.Helper()
+	if m.Stale() {
+		t.Fatalf("AssertLookupConsistent needs an up-to-date lookup table, call Rebuild first")
+	}
+	partitions := m.Partitions()
+	keys := Keys(numSampleKeys)
+	for i := 0; i < numSampleKeys; i++ {
+		keys = append(keys, uint64(i)*partitions/uint64(numSampleKeys))
+	}
+	for _, key := range keys {
+		owner, err := m.PartitionOwner(m.PartitionID(key))
+		if err != nil {
+			t.Fatalf("PartitionOwner(PartitionID(%d)): %v", key, err)
+		}
+		if node := m.Lookup(key); node != owner {
+			t.Errorf("Lookup(%d) = %q, but partition %d is owned by %q", key, node, m.PartitionID(key), owner)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package maglevtest
+
+import (
+	"testing"
+
+	"maglev"
+)
+
+func TestAssertLookupConsistent(t *testing.T) {
+	h1, h2 := maglev.DefaultHashers()
+	m, err := maglev.NewWeightedMaglev(map[string]uint64{"a": 1, "b": 2, "c": 3}, 1009, h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertLookupConsistent(t, m, 1009)
+
+	// lookups of a resized ring go through the new lookup table
+	if err := m.Resize(2003); err != nil {
+		t.Fatal(err)
+	}
+	AssertLookupConsistent(t, m, 2003)
+}
@@ -0,0 +1,67 @@
+package maglevtest
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"maglev"
+)
+
+// AssertMinimalDisruption checks that m moves about as few keys as a consistent hash should when
+// a node is added or removed: it adds a node to a Clone of m and removes a node from another,
+// routes numSampleKeys keys of Keys with each and fails t unless the fraction of keys that
+// moved is within tolerance of 1/(N+1) after the addition and of 1/N after the removal, where
+// N is the number of nodes of m. m itself is not changed. It needs at least two nodes and
+// room for one more.
+func AssertMinimalDisruption(t testing.TB, m *maglev.Maglev, numSampleKeys int, tolerance float64) {
+	t.Helper()
+	nodes := m.Nodes()
+	n := len(nodes)
+	if n < 2 || uint64(n) >= m.Partitions() {
+		t.Fatalf("AssertMinimalDisruption needs at least 2 nodes and room for one more, got %d nodes and %d partitions",
+			n, m.Partitions())
+	}
+	keys := Keys(numSampleKeys)
+	// compare against an up-to-date lookup table in case m defers rebuilds
+	base := m.Clone()
+	base.Rebuild()
+
+	added := m.Clone()
+	probe := "maglevtest-probe"
+	for i := 0; added.Contains(probe); i++ {
+		probe = fmt.Sprintf("maglevtest-probe-%d", i)
+	}
+	if _, err := added.Add(probe); err != nil {
+		t.Fatalf("Add(%q): %v", probe, err)
+	}
+	added.Rebuild()
+	if moved := movedFraction(base, added, keys); math.Abs(moved-1/float64(n+1)) > tolerance {
+		t.Errorf("adding a node to %d nodes moved %.4f of the keys, want %.4f ± %.4f",
+			n, moved, 1/float64(n+1), tolerance)
+	}
+
+	removed := m.Clone()
+	if _, err := removed.Remove(nodes[0]); err != nil {
+		t.Fatalf("Remove(%q): %v", nodes[0], err)
+	}
+	removed.Rebuild()
+	if moved := movedFraction(base, removed, keys); math.Abs(moved-1/float64(n)) > tolerance {
+		t.Errorf("removing a node from %d nodes moved %.4f of the keys, want %.4f ± %.4f",
+			n, moved, 1/float64(n), tolerance)
+	}
+}
+
+// movedFraction returns the fraction of the keys that old and new route to different nodes.
+func movedFraction(old, new *maglev.Maglev, keys []uint64) float64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	moved := 0
+	for _, key := range keys {
+		if old.Lookup(key) != new.Lookup(key) {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
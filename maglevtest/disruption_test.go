@@ -0,0 +1,51 @@
+package maglevtest
+
+import (
+	"fmt"
+	"testing"
+
+	"maglev"
+)
+
+func TestAssertMinimalDisruption(t *testing.T) {
+	h1, h2 := maglev.DefaultHashers()
+	for _, numNodes := range []int{2, 5, 20} {
+		t.Run(fmt.Sprint(numNodes), func(t *testing.T) {
+			var nodes []string
+			for i := 0; i < numNodes; i++ {
+				nodes = append(nodes, fmt.Sprintf("node-%d", i))
+			}
+			m, err := maglev.NewMaglev(nodes, 65537, h1, h2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := m.Fingerprint()
+			AssertMinimalDisruption(t, m, 20000, 0.03)
+			if m.Fingerprint() != before || m.Size() != numNodes {
+				t.Error("AssertMinimalDisruption changed the ring")
+			}
+		})
+	}
+}
+
+func TestMovedFraction(t *testing.T) {
+	h1, h2 := maglev.DefaultHashers()
+	m, err := maglev.NewMaglev([]string{"a", "b"}, 13, h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := Keys(1000)
+	if got := movedFraction(m, m.Clone(), keys); got != 0 {
+		t.Errorf("movedFraction of a clone = %v, want 0", got)
+	}
+	other, err := maglev.NewMaglev([]string{"c"}, 13, h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := movedFraction(m, other, keys); got != 1 {
+		t.Errorf("movedFraction of disjoint rings = %v, want 1", got)
+	}
+	if got := movedFraction(m, other, nil); got != 0 {
+		t.Errorf("movedFraction without keys = %v, want 0", got)
+	}
+}
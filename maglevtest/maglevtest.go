@@ -0,0 +1,81 @@
+// Package maglevtest provides helpers for testing code that uses package maglev and for
+// checking the invariants of package maglev itself.
+package maglevtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"maglev"
+)
+
+// RunOperations decodes data into a sequence of Add and Remove calls on m, which must not use
+// deferred rebuilds, and checks after each call that Maglev is consistent: Validate succeeds,
+// Size matches the number of nodes that should be present, and every partition is owned by
+// one of them. It returns an error describing the first violation.
+//
+// Every two bytes of data form one operation: the low bit of the first byte selects Add or
+// Remove and the second byte selects one of 16 node names. RunOperations is meant to be
+// called from a fuzz target, so that the fuzzer explores sequences of operations, as
+// FuzzRingOperations in this package does. Run it with
+//
+//	go test -fuzz=FuzzRingOperations maglev/maglevtest
+func RunOperations(m *maglev.Maglev, data []byte) error {
+	present := make(map[string]bool)
+	for _, node := range m.Nodes() {
+		present[node] = true
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		node := fmt.Sprintf("node-%d", data[i+1]%16)
+		if data[i]&1 == 0 {
+			if _, err := m.Add(node); err != nil {
+				if uint64(len(present)) < m.Partitions() {
+					return fmt.Errorf("operation %d: Add(%q): %w", i/2, node, err)
+				}
+			} else {
+				present[node] = true
+			}
+		} else {
+			if _, err := m.Remove(node); err != nil {
+				// Remove fails if it would leave Maglev without nodes
+				empty := len(present) == 0 || (len(present) == 1 && present[node])
+				if !errors.Is(err, maglev.ErrEmptyRing) || !empty {
+					return fmt.Errorf("operation %d: Remove(%q): %w", i/2, node, err)
+				}
+			} else {
+				delete(present, node)
+			}
+		}
+		if err := check(m, present); err != nil {
+			return fmt.Errorf("operation %d: %w", i/2, err)
+		}
+	}
+	return nil
+}
+
+// check returns an error unless m is consistent and contains exactly the present nodes.
+func check(m *maglev.Maglev, present map[string]bool) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if m.Size() != len(present) {
+		return fmt.Errorf("Size() = %d, want %d", m.Size(), len(present))
+	}
+	want := make([]string, 0, len(present))
+	for node := range present {
+		want = append(want, node)
+	}
+	sort.Strings(want)
+	if got := m.Nodes(); fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("Nodes() = %v, want %v", got, want)
+	}
+	var err error
+	m.Range(func(partition int, node string) bool {
+		if !present[node] {
+			err = fmt.Errorf("partition %d is owned by %q, which is not present", partition, node)
+		}
+		return err == nil
+	})
+	return err
+}
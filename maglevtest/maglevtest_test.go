@@ -0,0 +1,34 @@
+package maglevtest
+
+import (
+	"testing"
+
+	"maglev"
+)
+
+func FuzzRingOperations(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 1, 0, 2, 1, 1})
+	// remove the only node, and a node that is not present
+	f.Add([]byte{0, 1, 1, 1, 1, 2})
+	// add the first and the last node names, around the sort boundaries
+	f.Add([]byte{0, 0, 0, 15, 0, 9, 1, 15, 1, 0, 0, 15})
+	// add every node name twice, then remove them all
+	var all []byte
+	for op := byte(0); op < 3; op++ {
+		for node := byte(0); node < 16; node++ {
+			all = append(all, op/2, node)
+		}
+	}
+	f.Add(all)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h1, h2 := maglev.DefaultHashers()
+		m, err := maglev.NewMaglev(nil, 31, h1, h2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RunOperations(m, data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
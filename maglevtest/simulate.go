@@ -0,0 +1,101 @@
+package maglevtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"maglev"
+)
+
+// SimResult is the outcome of Simulate.
+type SimResult struct {
+	Keys          int            // number of keys routed
+	Elapsed       time.Duration  // time spent routing the keys
+	KeysPerSecond float64        // Keys / Elapsed
+	Hits          map[string]int // number of keys routed to each node, including nodes without keys
+	CV            float64        // coefficient of variation of Hits, i.e. stddev / mean
+}
+
+// Simulate routes numKeys pseudo-random keys with m and reports the throughput and how evenly
+// the keys were spread over the nodes. The keys are the same in every call, so results can be
+// compared across hashers and numbers of partitions.
+func Simulate(m *maglev.Maglev, numKeys int) SimResult {
+	keys := Keys(numKeys)
+	nodes := make([]string, len(keys))
+	start := time.Now()
+	_ = m.LookupAllInto(keys, nodes)
+	elapsed := time.Since(start)
+
+	result := SimResult{
+		Keys:    numKeys,
+		Elapsed: elapsed,
+		Hits:    make(map[string]int),
+	}
+	if elapsed > 0 {
+		result.KeysPerSecond = float64(numKeys) / elapsed.Seconds()
+	}
+	for _, node := range m.Nodes() {
+		result.Hits[node] = 0
+	}
+	for _, node := range nodes {
+		result.Hits[node]++
+	}
+	result.CV = cv(result.Hits)
+	return result
+}
+
+// BenchmarkLookup benchmarks Lookup on m with the keys of Simulate and reports the coefficient
+// of variation of the keys per node as the metric "cv". Call it from a benchmark function to
+// run it with go test -bench:
+//
+//	func BenchmarkLookup(b *testing.B) {
+//		h1, h2 := maglev.DefaultHashers()
+//		m, _ := maglev.NewMaglev(nodes, 65537, h1, h2)
+//		maglevtest.BenchmarkLookup(b, m)
+//	}
+func BenchmarkLookup(b *testing.B, m *maglev.Maglev) {
+	keys := Keys(1 << 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Lookup(keys[i&(len(keys)-1)])
+	}
+	b.StopTimer()
+	b.ReportMetric(Simulate(m, len(keys)).CV, "cv")
+}
+
+// Keys returns n pseudo-random keys. The same n always yields the same keys.
+func Keys(n int) []uint64 {
+	keys := make([]uint64, n)
+	var x uint64
+	for i := range keys {
+		// SplitMix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		keys[i] = z ^ z>>31
+	}
+	return keys
+}
+
+// cv returns the coefficient of variation of the counts, or 0 if there are none.
+func cv(counts map[string]int) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, n := range counts {
+		sum += float64(n)
+	}
+	mean := sum / float64(len(counts))
+	if mean == 0 {
+		return 0
+	}
+	var variance float64
+	for _, n := range counts {
+		variance += (float64(n) - mean) * (float64(n) - mean)
+	}
+	variance /= float64(len(counts))
+	return math.Sqrt(variance) / mean
+}
@@ -0,0 +1,59 @@
+package maglevtest
+
+import (
+	"fmt"
+	"testing"
+
+	"maglev"
+)
+
+func newRing(tb testing.TB, numNodes int, numPartitions uint64) *maglev.Maglev {
+	tb.Helper()
+	var nodes []string
+	for i := 0; i < numNodes; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	h1, h2 := maglev.DefaultHashers()
+	m, err := maglev.NewMaglev(nodes, numPartitions, h1, h2)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return m
+}
+
+func TestSimulate(t *testing.T) {
+	m := newRing(t, 10, 65537)
+	result := Simulate(m, 100000)
+	if result.Keys != 100000 || len(result.Hits) != 10 {
+		t.Fatalf("Simulate routed %d keys to %d nodes, want 100000 to 10", result.Keys, len(result.Hits))
+	}
+	total := 0
+	for _, n := range result.Hits {
+		total += n
+	}
+	if total != result.Keys {
+		t.Errorf("Hits add up to %d, want %d", total, result.Keys)
+	}
+	if result.CV <= 0 || result.CV > 0.05 {
+		t.Errorf("CV = %.4f, want a small positive value", result.CV)
+	}
+	if again := Simulate(m, 100000); fmt.Sprint(again.Hits) != fmt.Sprint(result.Hits) {
+		t.Error("Simulate is not reproducible")
+	}
+}
+
+func TestCV(t *testing.T) {
+	if got := cv(map[string]int{"a": 5, "b": 5}); got != 0 {
+		t.Errorf("cv of equal counts = %v, want 0", got)
+	}
+	if got := cv(map[string]int{"a": 0, "b": 2}); got != 1 {
+		t.Errorf("cv of {0, 2} = %v, want 1", got)
+	}
+	if got := cv(nil); got != 0 {
+		t.Errorf("cv(nil) = %v, want 0", got)
+	}
+}
+
+func BenchmarkSimulatedLookup(b *testing.B) {
+	BenchmarkLookup(b, newRing(b, 10, 65537))
+}
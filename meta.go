@@ -0,0 +1,40 @@
+package maglev
+
+import "fmt"
+
+// SetMeta attaches metadata such as an address or a zone to the node, replacing any previous
+// metadata, so that it can be retrieved together with the node by LookupWithMeta. The
+// metadata is kept while the node is present and dropped when it is removed. Returns an
+// error if the node is not in Maglev.
+func (m *Maglev) SetMeta(node string, meta any) error {
+	return m.modify(func(s *state) error {
+		if !s.contains(node) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, node)
+		}
+		s.meta = s.copyMeta()
+		s.meta[node] = meta
+		return nil
+	})
+}
+
+// LookupWithMeta returns the node the key belongs to and its metadata as set by SetMeta, or
+// nil if the node has no metadata. The node and the metadata are taken from the same
+// snapshot, so they are consistent even if Maglev is modified concurrently.
+func (m *Maglev) LookupWithMeta(key uint64) (string, any) {
+	s := m.load()
+	s.observeLookups(1)
+	node := s.owner(uint64(s.partitionID(key)))
+	if s.lookup == nil {
+		return node, nil
+	}
+	return node, s.meta[node]
+}
+
+// copyMeta returns a copy of the metadata that can be modified.
+func (s *state) copyMeta() map[string]any {
+	meta := make(map[string]any, len(s.meta)+1)
+	for node, v := range s.meta {
+		meta[node] = v
+	}
+	return meta
+}
@@ -0,0 +1,41 @@
+package maglev
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := map[string]string{"a": "10.0.0.1:80", "b": "10.0.0.2:80"}
+	for node, addr := range addrs {
+		if err := m.SetMeta(node, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key := uint64(0); key < 100; key++ {
+		node, meta := m.LookupWithMeta(key)
+		if node != m.Lookup(key) || meta != addrs[node] {
+			t.Fatalf("LookupWithMeta(%d) = %q, %v, want %q, %q", key, node, meta, m.Lookup(key), addrs[node])
+		}
+	}
+	if err := m.SetMeta("c", "10.0.0.3:80"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("SetMeta of an unknown node returned %v, want ErrNodeNotFound", err)
+	}
+
+	// metadata is dropped with its node and not restored when the node comes back
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 100; key++ {
+		if node, meta := m.LookupWithMeta(key); node == "a" && meta != nil {
+			t.Fatalf("LookupWithMeta(%d) = %q, %v after re-adding a, want no metadata", key, node, meta)
+		}
+	}
+}
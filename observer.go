@@ -0,0 +1,32 @@
+package maglev
+
+import "time"
+
+// Observer receives events from a Maglev, e.g. to export them as metrics. Set it with
+// WithObserver. Its methods are called synchronously and must be safe for concurrent use.
+type Observer interface {
+	// OnRebuild is called after a change of the nodes or the number of partitions has been
+	// published with a new lookup table, with the time it took to compute the change and the
+	// number of partitions whose owner changed. It is called after the Maglev has been
+	// unlocked, so it may call any of its methods; concurrent changes may thus be reported
+	// in a different order than they were published.
+	OnRebuild(duration time.Duration, partitionsChanged int)
+	// OnLookup is called for every key routed by one of the Lookup methods.
+	OnLookup()
+}
+
+// observeLookups reports n lookups to the observer, if there is one.
+func (c *config) observeLookups(n int) {
+	if c.observer == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.observer.OnLookup()
+	}
+}
+
+// sameTable returns true if a and b are the same lookup table. Lookup tables are replaced
+// rather than modified when they are rebuilt, so a changed table has a different backing array.
+func sameTable(a, b []int32) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
@@ -0,0 +1,120 @@
+package maglev
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	rebuilds []int
+	lookups  int
+}
+
+func (o *recordingObserver) OnRebuild(_ time.Duration, partitionsChanged int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rebuilds = append(o.rebuilds, partitionsChanged)
+}
+
+func (o *recordingObserver) OnLookup() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lookups++
+}
+
+func TestObserverResize(t *testing.T) {
+	o := &recordingObserver{}
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{}, WithObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resize(101); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.rebuilds) != 1 || o.rebuilds[0] != 101 {
+		t.Errorf("OnRebuild calls = %v, want [101]", o.rebuilds)
+	}
+	// the ring must still be usable after the observer ran
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+type rebuildFunc func()
+
+func (f rebuildFunc) OnRebuild(time.Duration, int) { f() }
+
+func (rebuildFunc) OnLookup() {}
+
+func TestObserverModifies(t *testing.T) {
+	var m *Maglev
+	removed := false
+	o := rebuildFunc(func() {
+		if !removed {
+			removed = true
+			if _, err := m.Remove("a"); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{}, WithObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AttachReplica(standby); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Contains("a") || standby.Contains("a") {
+		t.Error("node removed by the observer is still present")
+	}
+	if !m.Equal(standby) {
+		t.Error("standby differs from its primary")
+	}
+}
+
+func TestObserverAdd(t *testing.T) {
+	o := &recordingObserver{}
+	var nodes []string
+	for i := 0; i < 9; i++ {
+		nodes = append(nodes, string(rune('a'+i)))
+	}
+	m, err := NewMaglev(nodes, 10007, XXHasher{}, FNVHasher{}, WithObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("j"); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.rebuilds) != 1 {
+		t.Fatalf("OnRebuild calls = %v, want one", o.rebuilds)
+	}
+	// the new node takes about a tenth of the partitions
+	if got := o.rebuilds[0]; got < 10007/10 || got > 10007/10*11/10 {
+		t.Errorf("OnRebuild reported %d partitions changed, want about %d", got, 10007/10)
+	}
+	if _, err := m.Add("j"); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.rebuilds) != 1 {
+		t.Errorf("adding a present node called OnRebuild: %v", o.rebuilds)
+	}
+
+	m.Lookup(1)
+	m.LookupString("key")
+	m.LookupAll([]uint64{1, 2, 3})
+	if o.lookups != 5 {
+		t.Errorf("OnLookup called %d times, want 5", o.lookups)
+	}
+}
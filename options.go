@@ -0,0 +1,111 @@
+package maglev
+
+// Option configures optional behavior of a Maglev at construction time.
+type Option func(*Maglev)
+
+// WithKeyHasher sets the hasher used by LookupString and LookupBytes to hash keys. It is
+// independent of the hashers used to generate permutations. If h also implements ByteHasher,
+// LookupBytes hashes keys without converting them to strings. Defaults to XXHasher.
+func WithKeyHasher(h Hasher) Option {
+	return func(m *Maglev) {
+		m.setKeyHasher(h)
+	}
+}
+
+// WithDeferredRebuild makes Add and Remove skip rebuilding the lookup table, so that a
+// series of mutations only pays for a single rebuild when Rebuild is called. Until then,
+// lookups are served from the table as it was after the last rebuild, while Size, Contains and
+// Nodes reflect the changes; methods that need an up-to-date table, such as PartitionOwner and
+// MarshalBinary, return ErrStale. Use Stale to check whether a rebuild is pending.
+func WithDeferredRebuild() Option {
+	return func(m *Maglev) {
+		m.deferRebuild = true
+	}
+}
+
+// WithStrictValidation makes the constructor check the hashers with ValidateHashers and
+// return an error if they are unsuitable, e.g. because the same Hasher was passed as h1 and h2.
+// The constructor also returns an error for duplicate nodes instead of ignoring them.
+func WithStrictValidation() Option {
+	return func(m *Maglev) {
+		m.strict = true
+	}
+}
+
+// WithHashOrder makes nodes claim partitions in the order of their h1 hash rather than of
+// their names, which are only used to break ties. Under the default order, renaming a node
+// moves it past the nodes between its old and new name, e.g. when host-9 becomes host-09, and
+// that changes which of them win contested partitions. With hash order, a node's position is
+// unrelated to how its name sorts. Nodes and the other methods that return sorted nodes use
+// the same order. It changes the lookup table of any Maglev with more than one node, so all
+// instances that must route keys alike have to use it.
+func WithHashOrder() Option {
+	return func(m *Maglev) {
+		m.hashOrder = true
+	}
+}
+
+// WithNodeOrder makes nodes claim partitions in the order defined by less rather than by
+// name, e.g. to order nodes named by IP address and port numerically. less must be a strict
+// weak order; nodes it considers equal are ordered by name. Nodes and the other methods that
+// return sorted nodes use the same order, and it takes precedence over WithHashOrder. Like
+// WithHashOrder, it changes the lookup table, so all instances that must route keys alike have
+// to use the same order.
+func WithNodeOrder(less func(a, b string) bool) Option {
+	return func(m *Maglev) {
+		m.less = less
+	}
+}
+
+// WithMaxLoadFactor bounds the number of partitions of every node to c times its share by
+// weight, rounded up. While the lookup table is populated, a node that has reached its bound
+// stops claiming partitions, and the partitions it would have claimed go to the nodes that
+// claim them next. Nodes already claim partitions in proportion to their weights, so the bound
+// mostly matters for pinned partitions, which count towards it; tighter bounds move more
+// partitions when nodes change. The constructor returns an
+// error if c is less than 1.
+func WithMaxLoadFactor(c float64) Option {
+	return func(m *Maglev) {
+		m.maxLoad = c
+	}
+}
+
+// WithPermutationCache keeps the permutations of the n most recently removed nodes, so that
+// adding one of them again, e.g. after a node flapped, reuses its permutations instead of
+// generating them, which takes time proportional to the number of partitions. The cache costs
+// up to n times the memory of the permutations of a node. See PermutationCacheHits.
+func WithPermutationCache(n int) Option {
+	return func(m *Maglev) {
+		if n > 0 {
+			m.permCache = newPermutationCache(n)
+		}
+	}
+}
+
+// WithAutoPrime makes the constructor round the number of partitions up to the next prime
+// instead of returning an error if it is not prime. Use Partitions to get the actual number.
+func WithAutoPrime() Option {
+	return func(m *Maglev) {
+		m.autoPrime = true
+	}
+}
+
+// WithReplicas generates n permutations per node, under the synthetic names node#0 through
+// node#(n-1), and lets a node take turns among them when it claims partitions while populating
+// the lookup table. Lookups still return the real node names. Replicas reduce the dependence of
+// a node's partitions on a single offset and skip, which helps with poor hashers. They do not
+// tighten the balance, which is within one partition per node regardless (see MaxImbalance),
+// and they multiply the memory and time used for permutations by n.
+func WithReplicas(n int) Option {
+	return func(m *Maglev) {
+		m.replicas = n
+	}
+}
+
+// WithObserver makes Maglev report rebuilds and lookups to o. Without an observer, no events
+// are recorded.
+func WithObserver(o Observer) Option {
+	return func(m *Maglev) {
+		m.observer = o
+	}
+}
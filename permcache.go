@@ -0,0 +1,128 @@
+package maglev
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// permutationCache holds the permutations of recently removed nodes, so that re-adding a node
+// does not generate them again, see WithPermutationCache. Permutations are never modified once
+// generated, so they can be shared with the states that still refer to them.
+type permutationCache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *permutationCacheEntry, most recently used first
+	hits    atomic.Uint64
+}
+
+type permutationCacheEntry struct {
+	node         string
+	permutations [][]uint64 // of all replicas of node
+}
+
+func newPermutationCache(size int) *permutationCache {
+	return &permutationCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// peek returns the cached permutations of the node, or nil if they are not cached or were
+// generated for a different number of partitions or replicas, e.g. before Resize. It neither
+// counts as a hit nor as a use, since the state they are taken for may never be published,
+// see use.
+func (c *permutationCache) peek(node string, numPartitions uint64, replicas int) [][]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[node]
+	if !ok {
+		return nil
+	}
+	permutations := e.Value.(*permutationCacheEntry).permutations
+	if len(permutations) != replicas || uint64(len(permutations[0])) != numPartitions {
+		return nil
+	}
+	return permutations
+}
+
+// use counts a hit and marks the node as recently used if permutations were taken from the
+// cache by peek.
+func (c *permutationCache) use(node string, permutations [][]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[node]
+	if !ok {
+		return
+	}
+	// peek returns the cached slices themselves, generated ones never share their arrays
+	if cached := e.Value.(*permutationCacheEntry).permutations; &cached[0][0] == &permutations[0][0] {
+		c.lru.MoveToFront(e)
+		c.hits.Add(1)
+	}
+}
+
+// put caches the permutations of the node, evicting the least recently used node if full.
+func (c *permutationCache) put(node string, permutations [][]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[node]; ok {
+		e.Value.(*permutationCacheEntry).permutations = permutations
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[node] = c.lru.PushFront(&permutationCacheEntry{node, permutations})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*permutationCacheEntry).node)
+	}
+}
+
+// permutationsForNode returns the permutations of all replicas of the node, taking them from
+// the permutation cache if possible.
+func (s *state) permutationsForNode(node string) [][]uint64 {
+	if s.permCache != nil {
+		if permutations := s.permCache.peek(node, s.numPartitions, s.numReplicas()); permutations != nil {
+			return append([][]uint64(nil), permutations...)
+		}
+	}
+	return s.generatePermutationsForNode(node)
+}
+
+// updatePermutationCache caches the permutations of the nodes of old that s no longer has,
+// and counts the nodes s added with permutations from the cache as hits. It is only called
+// for states that are published, so that computing a state that is then discarded, e.g. for
+// RemovalImpact, Plan or an aborted Add, neither evicts nodes that were actually removed nor
+// counts hits for nodes that were never added.
+func (s *state) updatePermutationCache(old *state) {
+	if s.permCache == nil {
+		return
+	}
+	r := s.numReplicas()
+	if s.permutations != nil {
+		for i, node := range s.nodes {
+			if !old.contains(node) {
+				s.permCache.use(node, s.permutations[i*r:(i+1)*r])
+			}
+		}
+	}
+	if old.permutations == nil {
+		return
+	}
+	for i, node := range old.nodes {
+		if !s.contains(node) {
+			s.permCache.put(node, append([][]uint64(nil), old.permutations[i*r:(i+1)*r]...))
+		}
+	}
+}
+
+// PermutationCacheHits returns the number of times a node was added with permutations taken
+// from the cache of WithPermutationCache instead of generating them, or 0 without a cache.
+func (m *Maglev) PermutationCacheHits() uint64 {
+	if m.permCache == nil {
+		return 0
+	}
+	return m.permCache.hits.Load()
+}
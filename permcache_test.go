@@ -0,0 +1,89 @@
+package maglev
+
+import "testing"
+
+func TestPermutationCache(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	m, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{}, WithPermutationCache(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewMaglev(nodes, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	// computing changes without applying them must not evict "a"
+	m.RemovalImpact("b")
+	if _, err := m.Plan([]string{"c", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	m.WouldMove(1, "e")
+
+	if _, err := m.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := m.PermutationCacheHits(); hits != 1 {
+		t.Errorf("PermutationCacheHits() = %d, want 1", hits)
+	}
+	if !m.Equal(want) {
+		t.Error("re-adding a cached node produced a different ring")
+	}
+}
+
+func TestPermutationCacheReconcile(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{}, WithPermutationCache(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reconcile([]string{"c", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reconcile([]string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if hits := m.PermutationCacheHits(); hits != 2 {
+		t.Errorf("PermutationCacheHits() = %d, want 2", hits)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPermutationCacheDryRun(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{}, WithPermutationCache(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	// computing a state with "a" added again without publishing it is not a hit
+	if _, err := m.Plan([]string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	m.WouldMove(1, "a")
+	standby, err := NewMaglev(nil, 2, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AttachReplica(standby); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("a"); err == nil {
+		t.Fatal("Add succeeded although the standby has fewer partitions than nodes")
+	}
+	if hits := m.PermutationCacheHits(); hits != 0 {
+		t.Errorf("PermutationCacheHits() = %d after dry runs, want 0", hits)
+	}
+
+	m.DetachReplica(standby)
+	if _, err := m.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := m.PermutationCacheHits(); hits != 1 {
+		t.Errorf("PermutationCacheHits() = %d, want 1", hits)
+	}
+}
@@ -0,0 +1,57 @@
+package maglev
+
+import "fmt"
+
+// Pin assigns the partition with the given id to the node regardless of the permutations,
+// e.g. to keep a partition in place during a migration. The other nodes skip the partition
+// while populating the lookup table. The pin is kept across rebuilds until the partition is
+// unpinned with Unpin or the node is removed. Pins only affect the lookup table; they are not
+// part of the encoding produced by MarshalBinary. Returns an error if the id is out of range
+// or the node is not in Maglev.
+func (m *Maglev) Pin(partitionID int, node string) error {
+	return m.modify(func(s *state) error {
+		if err := s.checkPartition(partitionID); err != nil {
+			return err
+		}
+		if !s.contains(node) {
+			return fmt.Errorf("%w: %q", ErrNodeNotFound, node)
+		}
+		if s.h1 == nil {
+			return errNoHashers
+		}
+		if owner, ok := s.pins[uint64(partitionID)]; ok && owner == node {
+			return errUnchanged
+		}
+		s.pins = s.copyPins()
+		s.pins[uint64(partitionID)] = node
+		s.update()
+		return nil
+	})
+}
+
+// Unpin removes the pin of the partition with the given id, so that it is assigned by the
+// permutations again. Unpinning a partition that is not pinned has no effect. Returns an error
+// if the id is out of range.
+func (m *Maglev) Unpin(partitionID int) error {
+	return m.modify(func(s *state) error {
+		if err := s.checkPartition(partitionID); err != nil {
+			return err
+		}
+		if _, ok := s.pins[uint64(partitionID)]; !ok {
+			return errUnchanged
+		}
+		s.pins = s.copyPins()
+		delete(s.pins, uint64(partitionID))
+		s.update()
+		return nil
+	})
+}
+
+// copyPins returns a copy of the pins that can be modified.
+func (s *state) copyPins() map[uint64]string {
+	pins := make(map[uint64]string, len(s.pins)+1)
+	for partition, node := range s.pins {
+		pins[partition] = node
+	}
+	return pins
+}
@@ -0,0 +1,65 @@
+package maglev
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := map[int]string{}
+	for partition := 0; len(pins) < 3; partition++ {
+		// pin partitions away from their owners, so the pins are observable
+		if owner, _ := m.PartitionOwner(partition); owner != "a" {
+			pins[partition] = "a"
+		}
+	}
+	for partition, node := range pins {
+		if err := m.Pin(partition, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string) {
+		t.Helper()
+		for partition, node := range pins {
+			if owner, _ := m.PartitionOwner(partition); owner != node {
+				t.Errorf("%s: partition %d is owned by %q, want pinned node %q", when, partition, owner, node)
+			}
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s: %v", when, err)
+		}
+	}
+	check("after Pin")
+	if _, err := m.Add("d", "e"); err != nil {
+		t.Fatal(err)
+	}
+	check("after Add")
+	if _, err := m.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	check("after Remove")
+
+	for partition := range pins {
+		if err := m.Unpin(partition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := NewMaglev(m.Nodes(), 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(want) {
+		t.Error("ring differs from an unpinned one after Unpin")
+	}
+
+	if err := m.Pin(0, "x"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Pin to an unknown node returned %v, want ErrNodeNotFound", err)
+	}
+	if err := m.Pin(101, "a"); err == nil {
+		t.Error("Pin of an out-of-range partition succeeded")
+	}
+}
@@ -0,0 +1,33 @@
+package maglev
+
+// ChangePlan describes the effect that Reconcile would have on a Maglev, see Plan.
+type ChangePlan struct {
+	ToAdd        []string       // sorted nodes that would be added
+	ToRemove     []string       // sorted nodes that would be removed
+	Moved        int            // number of partitions that would be assigned to a different node
+	Distribution map[string]int // number of partitions each node would own afterwards
+}
+
+// Plan returns what Reconcile(desired) would do without changing Maglev: the nodes that would
+// be added and removed, the number of partitions that would move and the resulting
+// distribution. Errors are reported as in Reconcile. With WithDeferredRebuild, the plan
+// assumes that Rebuild is called afterwards, and movement is counted against the lookup table
+// that currently serves lookups.
+func (m *Maglev) Plan(desired []string) (ChangePlan, error) {
+	old := m.load()
+	s := *old
+	var plan ChangePlan
+	plan.ToAdd, plan.ToRemove = s.diff(desired)
+	if err := s.reconcile(desired); err == errUnchanged {
+		plan.Distribution = old.distribution()
+		return plan, nil
+	} else if err != nil {
+		return ChangePlan{}, err
+	}
+	if s.stale {
+		s.populateLookup()
+	}
+	plan.Moved = len(s.changedSince(old))
+	plan.Distribution = s.distribution()
+	return plan, nil
+}
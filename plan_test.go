@@ -0,0 +1,41 @@
+package maglev
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	for _, desired := range [][]string{
+		{"a", "b", "c", "d"},
+		{"b", "c", "e"},
+		{"x", "y"},
+		{"a", "b", "c"},
+	} {
+		m, err := NewMaglev([]string{"a", "b", "c"}, 1009, XXHasher{}, FNVHasher{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := m.Plan(desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Size() != 3 {
+			t.Fatal("Plan changed the ring")
+		}
+		toAdd, toRemove := m.Diff(desired)
+		if fmt.Sprint(plan.ToAdd, plan.ToRemove) != fmt.Sprint(toAdd, toRemove) {
+			t.Errorf("%v: plan adds %v and removes %v, want %v and %v", desired, plan.ToAdd, plan.ToRemove, toAdd, toRemove)
+		}
+		before := m.Clone()
+		if err := m.Reconcile(desired); err != nil {
+			t.Fatal(err)
+		}
+		if moved, _, _ := Disruption(before, m); plan.Moved != moved {
+			t.Errorf("%v: plan moves %d partitions, Reconcile moved %d", desired, plan.Moved, moved)
+		}
+		if fmt.Sprint(plan.Distribution) != fmt.Sprint(m.Distribution()) {
+			t.Errorf("%v: plan distribution %v, got %v", desired, plan.Distribution, m.Distribution())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package maglev
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// isPrime reports whether n is prime. ProbablyPrime(0) runs the Baillie-PSW test, which is
+// exact for all 64-bit values.
+func isPrime(n uint64) bool {
+	return big.NewInt(0).SetUint64(n).ProbablyPrime(0)
+}
+
+// checkPartitions returns an error unless n is a valid number of partitions, i.e. a prime that
+// fits into an int, so that partition ids can be represented as ints on every platform.
+// The error wraps ErrNotPrime. 0 and 1 get a hint that the smallest valid number is 2, since
+// they are more likely to be an unset value than a mistaken prime.
+func checkPartitions(n uint64) error {
+	if n < 2 {
+		return fmt.Errorf("%w: got %d, the smallest is 2", ErrNotPrime, n)
+	}
+	if !isPrime(n) {
+		return fmt.Errorf("%w: got %d", ErrNotPrime, n)
+	}
+	if n > math.MaxInt {
+		return fmt.Errorf("number of partitions %d overflows int", n)
+	}
+	return nil
+}
+
+// NextPrime returns the smallest prime greater than or equal to n, or 0 if there is no such
+// prime representable as a uint64.
+func NextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; !isPrime(n); n += 2 {
+		if n >= math.MaxUint64-1 {
+			return 0
+		}
+	}
+	return n
+}
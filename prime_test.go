@@ -0,0 +1,87 @@
+package maglev
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestNextPrime(t *testing.T) {
+	tests := []struct{ n, want uint64 }{
+		{0, 2},
+		{1, 2},
+		{2, 2},
+		{3, 3},
+		{4, 5},
+		{14, 17},
+		{65536, 65537},
+		{65537, 65537},
+		{1000000, 1000003},
+		{1 << 32, 4294967311},
+		{18446744073709551557, 18446744073709551557}, // the largest 64-bit prime
+		{18446744073709551558, 0},
+	}
+	for _, tt := range tests {
+		if got := NextPrime(tt.n); got != tt.want {
+			t.Errorf("NextPrime(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestAutoPrime(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 100, XXHasher{}, FNVHasher{}, WithAutoPrime())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Partitions() != 101 {
+		t.Errorf("Partitions() = %d, want 101", m.Partitions())
+	}
+	if _, err := NewMaglev([]string{"a", "b"}, 100, XXHasher{}, FNVHasher{}); !errors.Is(err, ErrNotPrime) {
+		t.Errorf("NewMaglev with 100 partitions returned %v, want ErrNotPrime", err)
+	}
+}
+
+func TestCheckPartitionsOverflow(t *testing.T) {
+	tooLarge := NextPrime(uint64(math.MaxInt) + 1)
+	if err := checkPartitions(tooLarge); err == nil {
+		t.Errorf("checkPartitions(%d) accepted a number of partitions that overflows int", tooLarge)
+	}
+	if _, err := NewMaglev([]string{"a"}, tooLarge, XXHasher{}, FNVHasher{}); err == nil {
+		t.Errorf("NewMaglev with %d partitions succeeded", tooLarge)
+	}
+}
+
+func TestPartitionIDRange(t *testing.T) {
+	// a state with more partitions than fit into an int32, without populating its table
+	s := &state{config: &config{}, numPartitions: NextPrime(math.MaxInt32 + 1)}
+	if uint64(math.MaxInt) < s.numPartitions {
+		t.Skip("partition count does not fit into an int on this platform")
+	}
+	for _, key := range []uint64{0, 1, math.MaxInt32, math.MaxUint32, 1 << 63, math.MaxUint64} {
+		if id := s.partitionID(key); id < 0 || uint64(id) != key%s.numPartitions {
+			t.Errorf("partitionID(%d) = %d, want %d", key, id, key%s.numPartitions)
+		}
+	}
+}
+
+func TestNumPartitionsInt(t *testing.T) {
+	m, err := NewMaglev([]string{"a"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.NumPartitionsInt(); n != 101 || err != nil {
+		t.Errorf("NumPartitionsInt() = %d, %v, want 101", n, err)
+	}
+
+	tooLarge := NextPrime(uint64(math.MaxInt) + 1)
+	data := appendUvarint([]byte{encodingVersion}, tooLarge)
+	data = appendUvarint(data, 0)
+	if err := m.UnmarshalBinary(data); err == nil {
+		t.Errorf("UnmarshalBinary accepted %d partitions", tooLarge)
+	}
+	// a state with more partitions than any constructor accepts
+	m.store(&state{config: &m.config, numPartitions: tooLarge})
+	if n, err := m.NumPartitionsInt(); err == nil {
+		t.Errorf("NumPartitionsInt() = %d for %d partitions, want an error", n, tooLarge)
+	}
+}
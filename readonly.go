@@ -0,0 +1,32 @@
+package maglev
+
+// ReadOnly is the subset of the methods of Maglev that do not modify it, e.g. for request
+// handlers that route keys but must not change the nodes.
+type ReadOnly interface {
+	Lookup(key uint64) string
+	PartitionID(key uint64) int
+	Contains(node string) bool
+	Size() int
+	Partitions() uint64
+	Nodes() []string
+}
+
+var _ ReadOnly = (*Maglev)(nil)
+
+// ReadOnly returns a view of m that only exposes the methods of ReadOnly. Unlike m itself, the
+// view cannot be converted back to a *Maglev with a type assertion. It reflects later changes
+// to m.
+func (m *Maglev) ReadOnly() ReadOnly {
+	return readOnly{m}
+}
+
+type readOnly struct {
+	m *Maglev
+}
+
+func (r readOnly) Lookup(key uint64) string   { return r.m.Lookup(key) }
+func (r readOnly) PartitionID(key uint64) int { return r.m.PartitionID(key) }
+func (r readOnly) Contains(node string) bool  { return r.m.Contains(node) }
+func (r readOnly) Size() int                  { return r.m.Size() }
+func (r readOnly) Partitions() uint64         { return r.m.Partitions() }
+func (r readOnly) Nodes() []string            { return r.m.Nodes() }
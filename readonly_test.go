@@ -0,0 +1,52 @@
+package maglev
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := m.ReadOnly()
+	if _, ok := view.(*Maglev); ok {
+		t.Fatal("view can be converted back to a *Maglev")
+	}
+	if _, ok := view.(interface {
+		Add(nodes ...string) (int, error)
+	}); ok {
+		t.Error("view exposes Add")
+	}
+	if _, ok := view.(interface {
+		Remove(nodes ...string) (int, error)
+	}); ok {
+		t.Error("view exposes Remove")
+	}
+	got, want := reflect.TypeOf(view).NumMethod(), reflect.TypeOf((*ReadOnly)(nil)).Elem().NumMethod()
+	if got != want {
+		t.Errorf("view has %d exported methods, want the %d of ReadOnly", got, want)
+	}
+
+	check := func() {
+		t.Helper()
+		for key := uint64(0); key < 1000; key++ {
+			if got, want := view.Lookup(key), m.Lookup(key); got != want {
+				t.Fatalf("view.Lookup(%d) = %q, want %q", key, got, want)
+			}
+			if got, want := view.PartitionID(key), m.PartitionID(key); got != want {
+				t.Fatalf("view.PartitionID(%d) = %d, want %d", key, got, want)
+			}
+		}
+		if !reflect.DeepEqual(view.Nodes(), m.Nodes()) || view.Size() != m.Size() ||
+			view.Partitions() != m.Partitions() || view.Contains("d") != m.Contains("d") {
+			t.Error("view differs from its Maglev")
+		}
+	}
+	check()
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
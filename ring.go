@@ -0,0 +1,23 @@
+package maglev
+
+// Ring routes keys of type K with a Maglev. Keys are converted to the uint64 that
+// Maglev.Lookup expects with the key function passed to NewRing.
+type Ring[K any] struct {
+	m   *Maglev
+	key func(K) uint64
+}
+
+// NewRing returns a Ring that routes keys with m, converting them with key.
+func NewRing[K any](m *Maglev, key func(K) uint64) *Ring[K] {
+	return &Ring[K]{m: m, key: key}
+}
+
+// Lookup returns the node the key belongs to.
+func (r *Ring[K]) Lookup(k K) string {
+	return r.m.Lookup(r.key(k))
+}
+
+// Maglev returns the underlying Maglev, e.g. to add or remove nodes.
+func (r *Ring[K]) Maglev() *Maglev {
+	return r.m
+}
@@ -0,0 +1,24 @@
+package maglev
+
+import "testing"
+
+func TestRing(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strs := NewRing(m, XXHasher{}.Hash)
+	bytes := NewRing(m, XXHasher{}.HashBytes)
+	for _, key := range []string{"", "user-1", "user-2", "session-42"} {
+		want := m.LookupString(key)
+		if got := strs.Lookup(key); got != want {
+			t.Errorf("Ring[string].Lookup(%q) = %q, want %q", key, got, want)
+		}
+		if got := bytes.Lookup([]byte(key)); got != want {
+			t.Errorf("Ring[[]byte].Lookup(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if strs.Maglev() != m {
+		t.Error("Maglev() does not return the underlying Maglev")
+	}
+}
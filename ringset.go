@@ -0,0 +1,61 @@
+package maglev
+
+import "errors"
+
+// RingSelector picks one of n rings for the key. It must return an index in [0, n) and
+// always pick the same ring for the same key and n.
+type RingSelector func(key uint64, n int) int
+
+// ModuloSelector picks ring key % n.
+func ModuloSelector(key uint64, n int) int {
+	return int(key % uint64(n))
+}
+
+// RingSet routes keys across several Maglev instances, e.g. to split a large number of nodes
+// into shards. A key is routed by picking a ring with the RingSelector and looking the key up in
+// that ring, so changing the nodes of one ring does not move keys routed to the others. The
+// rings are fixed when the RingSet is created, but their nodes may change at any time.
+type RingSet struct {
+	rings    []*Maglev
+	selector RingSelector
+}
+
+// NewRingSet returns a RingSet that routes keys across the rings with selector. A nil
+// selector defaults to ModuloSelector.
+func NewRingSet(rings []*Maglev, selector RingSelector) (*RingSet, error) {
+	if len(rings) == 0 {
+		return nil, errors.New("ring set needs at least one ring")
+	}
+	for _, ring := range rings {
+		if ring == nil {
+			return nil, errors.New("rings must not be nil")
+		}
+	}
+	if selector == nil {
+		selector = ModuloSelector
+	}
+	return &RingSet{
+		rings:    append([]*Maglev(nil), rings...),
+		selector: selector,
+	}, nil
+}
+
+// Lookup returns the node the key belongs to in the ring picked for it.
+func (r *RingSet) Lookup(key uint64) string {
+	return r.rings[r.RingFor(key)].Lookup(key)
+}
+
+// RingFor returns the index of the ring the key is routed to.
+func (r *RingSet) RingFor(key uint64) int {
+	return r.selector(key, len(r.rings))
+}
+
+// Ring returns the ring at index i, e.g. to add or remove nodes.
+func (r *RingSet) Ring(i int) *Maglev {
+	return r.rings[i]
+}
+
+// Len returns the number of rings.
+func (r *RingSet) Len() int {
+	return len(r.rings)
+}
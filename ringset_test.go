@@ -0,0 +1,46 @@
+package maglev
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingSet(t *testing.T) {
+	var rings []*Maglev
+	for i := 0; i < 3; i++ {
+		m, err := NewMaglev([]string{fmt.Sprintf("%d-a", i), fmt.Sprintf("%d-b", i)}, 101, XXHasher{}, FNVHasher{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rings = append(rings, m)
+	}
+	set, err := NewRingSet(rings, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", set.Len())
+	}
+	before := make([]string, 3000)
+	for key := range before {
+		before[key] = set.Lookup(uint64(key))
+		if want := set.Ring(key % 3).Lookup(uint64(key)); before[key] != want {
+			t.Fatalf("Lookup(%d) = %q, want %q from ring %d", key, before[key], want, key%3)
+		}
+	}
+	if _, err := set.Ring(1).Add("1-c"); err != nil {
+		t.Fatal(err)
+	}
+	for key, node := range before {
+		if set.RingFor(uint64(key)) != 1 && set.Lookup(uint64(key)) != node {
+			t.Fatalf("adding a node to ring 1 moved key %d of ring %d", key, set.RingFor(uint64(key)))
+		}
+	}
+
+	if _, err := NewRingSet(nil, nil); err == nil {
+		t.Error("NewRingSet without rings succeeded")
+	}
+	if _, err := NewRingSet([]*Maglev{rings[0], nil}, nil); err == nil {
+		t.Error("NewRingSet with a nil ring succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+package maglev
+
+import (
+	"errors"
+	"sync"
+)
+
+// attachMu serializes AttachReplica, so that concurrent calls cannot create a cycle.
+var attachMu sync.Mutex
+
+// AttachReplica makes r a standby of m that mirrors the nodes of m and their weights, e.g. for
+// a failover ring with different hashers. r is first brought in line with the current nodes of
+// m, and from then on every mutator of m applies the same change to r while m is locked. If
+// r cannot take the change, e.g. because it has fewer partitions
+// than nodes, the mutator returns an error and neither ring is changed. Changes made with
+// UnmarshalBinary are not mirrored, and mutating r directly makes it drift until the next
+// mutation of m. A standby may have standbys of its own, but a ring cannot be its own standby.
+func (m *Maglev) AttachReplica(r *Maglev) error {
+	if r == nil {
+		return errors.New("replica must not be nil")
+	}
+	attachMu.Lock()
+	defer attachMu.Unlock()
+	if r.reaches(m) {
+		return errors.New("replica would mirror itself")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, standby := range m.standbys {
+		if standby == r {
+			return nil
+		}
+	}
+	s := m.load()
+	tx, err := r.prepare(func(rs *state) error {
+		return rs.mirror(s)
+	})
+	if err != nil {
+		return err
+	}
+	if tx != nil {
+		tx.commit()
+	}
+	m.standbys = append(m.standbys[:len(m.standbys):len(m.standbys)], r)
+	return nil
+}
+
+// DetachReplica stops mirroring the nodes of m to r. It returns false if r is not a standby of
+// m.
+func (m *Maglev) DetachReplica(r *Maglev) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, standby := range m.standbys {
+		if standby == r {
+			m.standbys = append(m.standbys[:i:i], m.standbys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// reaches returns true if target is m or one of its standbys, directly or indirectly.
+func (m *Maglev) reaches(target *Maglev) bool {
+	if m == target {
+		return true
+	}
+	m.mu.Lock()
+	standbys := m.standbys
+	m.mu.Unlock()
+	for _, standby := range standbys {
+		if standby.reaches(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirror changes the nodes of s and their weights to those of p.
+func (s *state) mirror(p *state) error {
+	if s.h1 == nil {
+		return errNoHashers
+	}
+	if uint64(len(p.nodes)) > s.numPartitions {
+		return tooManyNodes(len(p.nodes), s.numPartitions)
+	}
+	removed := make(map[string]struct{})
+	for _, node := range s.nodes {
+		if !p.contains(node) {
+			removed[node] = struct{}{}
+		}
+	}
+	added := make(map[uint64]map[string]struct{})
+	var reweighted []string
+	for _, node := range p.nodes {
+		if err := checkWeight(p.weight(node), s.numPartitions); err != nil {
+			return err
+		}
+		if s.contains(node) {
+			if s.weight(node) != p.weight(node) {
+				reweighted = append(reweighted, node)
+			}
+			continue
+		}
+		w := p.weight(node)
+		if added[w] == nil {
+			added[w] = make(map[string]struct{})
+		}
+		added[w][node] = struct{}{}
+	}
+	if len(removed) == 0 && len(added) == 0 && len(reweighted) == 0 {
+		return errUnchanged
+	}
+
+	if len(p.nodes) == 0 {
+		s.drain()
+		return nil
+	}
+	if len(removed) > 0 {
+		s.deleteNodes(removed)
+	}
+	for w, nodes := range added {
+		s.insertNodes(nodes, w)
+	}
+	for _, node := range reweighted {
+		s.setWeight(node, p.weight(node))
+	}
+	s.update()
+	return nil
+}
@@ -0,0 +1,85 @@
+package maglev
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAttachReplica(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := NewMaglev([]string{"x"}, 101, FNVHasher{}, XXHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AttachReplica(standby); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.AttachReplica(m); err == nil {
+		t.Error("a ring became a standby of its own standby")
+	}
+	inSync := func(step string) {
+		t.Helper()
+		if got, want := standby.Nodes(), m.Nodes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("after %s, standby has nodes %v, want %v", step, got, want)
+		}
+		if err := standby.Validate(); err != nil {
+			t.Errorf("after %s: %v", step, err)
+		}
+	}
+	inSync("AttachReplica")
+	if _, err := m.Add("d", "e"); err != nil {
+		t.Fatal(err)
+	}
+	inSync("Add")
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	inSync("Remove")
+	if _, err := m.AddWeighted(3, "f"); err != nil {
+		t.Fatal(err)
+	}
+	inSync("AddWeighted")
+	if err := m.Reconcile([]string{"b", "f", "g"}); err != nil {
+		t.Fatal(err)
+	}
+	inSync("Reconcile")
+	if got := standby.Distribution()["f"]; got < 2*standby.Distribution()["g"] {
+		t.Errorf("standby did not mirror the weight of f: it owns %d partitions", got)
+	}
+
+	if !m.DetachReplica(standby) {
+		t.Fatal("DetachReplica did not find the standby")
+	}
+	if m.DetachReplica(standby) {
+		t.Error("DetachReplica found a detached standby")
+	}
+	if _, err := m.Add("h"); err != nil {
+		t.Fatal(err)
+	}
+	if standby.Contains("h") {
+		t.Error("detached standby still mirrors its primary")
+	}
+}
+
+func TestAttachReplicaTooSmall(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := NewMaglev(nil, 3, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AttachReplica(standby); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("c", "d"); err == nil {
+		t.Fatal("Add succeeded although the standby has fewer partitions than nodes")
+	}
+	if m.Contains("c") || standby.Contains("c") {
+		t.Error("failed Add changed a ring")
+	}
+}
@@ -0,0 +1,209 @@
+package maglev
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// RingStats summarizes the state of a Maglev.
+type RingStats struct {
+	Nodes      int     // number of nodes
+	Partitions uint64  // number of partitions
+	MinShare   int     // number of partitions owned by the least loaded node
+	MaxShare   int     // number of partitions owned by the busiest node
+	MeanShare  float64 // average number of partitions per node
+	Imbalance  float64 // MaxShare / MeanShare, see MaxImbalance
+}
+
+// Distribution returns the number of partitions owned by each node.
+func (m *Maglev) Distribution() map[string]int {
+	return m.load().distribution()
+}
+
+// distribution returns the number of partitions owned by each node.
+func (s *state) distribution() map[string]int {
+	counts := s.partitionCounts()
+	dist := make(map[string]int, len(counts))
+	for i, n := range counts {
+		dist[s.lookupNodes[i]] = n
+	}
+	return dist
+}
+
+// ExpectedShares returns the number of partitions each node would own in a ring with the given
+// weights and number of partitions if every node got exactly its share by weight. Shares are
+// rounded down, and the remaining partitions go to the nodes with the largest remainders, ties
+// broken by name, so they add up to numPartitions. Without pins, a weighted Maglev deviates from
+// them by about one partition per node. The map is empty if the weights add up to 0 or overflow
+// a uint64.
+func ExpectedShares(weights map[string]uint64, numPartitions uint64) map[string]int {
+	shares := make(map[string]int, len(weights))
+	var total, carry uint64
+	for _, w := range weights {
+		if total, carry = bits.Add64(total, w, 0); carry != 0 {
+			return shares
+		}
+	}
+	if total == 0 {
+		return shares
+	}
+	type remainder struct {
+		node string
+		rem  uint64
+	}
+	var rems []remainder
+	left := numPartitions
+	for node, w := range weights {
+		hi, lo := bits.Mul64(numPartitions, w)
+		share, rem := bits.Div64(hi, lo, total)
+		shares[node] = int(share)
+		left -= share
+		rems = append(rems, remainder{node, rem})
+	}
+	sort.Slice(rems, func(i, j int) bool {
+		if rems[i].rem != rems[j].rem {
+			return rems[i].rem > rems[j].rem
+		}
+		return rems[i].node < rems[j].node
+	})
+	for i := uint64(0); i < left; i++ {
+		shares[rems[i].node]++
+	}
+	return shares
+}
+
+// countsCache holds the partition counts of a published state, which are computed at most
+// once. Copies of the state share the cache but do not use it, since their lookup table may
+// differ.
+type countsCache struct {
+	owner  *state
+	once   sync.Once
+	counts []int
+}
+
+// partitionCounts returns the number of partitions owned by each node, indexed like
+// lookupNodes. The slice must not be modified.
+func (s *state) partitionCounts() []int {
+	c := s.counts
+	if c == nil || c.owner != s {
+		return s.countPartitions()
+	}
+	c.once.Do(func() {
+		c.counts = s.countPartitions()
+	})
+	return c.counts
+}
+
+func (s *state) countPartitions() []int {
+	counts := make([]int, len(s.lookupNodes))
+	for _, i := range s.lookup {
+		counts[i]++
+	}
+	return counts
+}
+
+// MaxImbalance returns the ratio of the number of partitions owned by the busiest node to
+// the average number of partitions per node. A perfectly balanced ring has a ratio of 1.
+// In an unweighted ring every node claims one partition per round while populating the
+// lookup table, so the ratio is at most 1 + Size()/Partitions(). MaxImbalance returns 0
+// for a ring without nodes.
+func (m *Maglev) MaxImbalance() float64 {
+	return m.Stats().Imbalance
+}
+
+// Stats returns a summary of the number of nodes and partitions and of how evenly the
+// partitions are shared among the nodes.
+func (m *Maglev) Stats() RingStats {
+	return m.load().stats()
+}
+
+func (s *state) stats() RingStats {
+	stats := RingStats{
+		Nodes:      len(s.lookupNodes),
+		Partitions: s.numPartitions,
+	}
+	if stats.Nodes == 0 {
+		return stats
+	}
+	counts := s.partitionCounts()
+	stats.MinShare = counts[0]
+	for _, n := range counts {
+		if n < stats.MinShare {
+			stats.MinShare = n
+		}
+		if n > stats.MaxShare {
+			stats.MaxShare = n
+		}
+	}
+	stats.MeanShare = float64(s.numPartitions) / float64(stats.Nodes)
+	stats.Imbalance = float64(stats.MaxShare) / stats.MeanShare
+	return stats
+}
+
+// String summarizes Maglev for logs, e.g. "Maglev{nodes: 3, partitions: 101, imbalance: 1.01}".
+// The imbalance is computed from the lookup table once per change of Maglev, so only the
+// first call after a change scans the table, as do Stats and Distribution. A
+// Maglev whose lookup table is stale, see WithDeferredRebuild, is marked as such.
+func (m *Maglev) String() string {
+	s := m.load()
+	stats := s.stats()
+	stale := ""
+	if s.stale {
+		stale = ", stale"
+	}
+	return fmt.Sprintf("Maglev{nodes: %d, partitions: %d, imbalance: %.2f%s}",
+		len(s.nodes), stats.Partitions, stats.Imbalance, stale)
+}
+
+// BalanceReport compares the number of partitions of every node with its share by weight.
+type BalanceReport struct {
+	Nodes        []NodeBalance // in the order of Nodes
+	MaxDeviation int           // largest absolute Deviation of a node
+	RMSDeviation float64       // root mean square of the Deviations
+}
+
+// NodeBalance is the entry of a node in a BalanceReport.
+type NodeBalance struct {
+	Node      string
+	Ideal     int // number of partitions the node would own, see ExpectedShares
+	Actual    int // number of partitions the node owns
+	Deviation int // Actual - Ideal
+}
+
+// BalanceReport returns how far the number of partitions of every node is from its share by
+// weight, e.g. to diagnose a poor hasher. The ideal shares are those of ExpectedShares, so
+// they add up to the number of partitions, as do the actual ones, and the deviations add up to
+// zero. With WithDeferredRebuild, the report describes the nodes of the current lookup table.
+func (m *Maglev) BalanceReport() BalanceReport {
+	s := m.load()
+	weights := make(map[string]uint64, len(s.lookupNodes))
+	for _, node := range s.lookupNodes {
+		weights[node] = s.weight(node)
+	}
+	ideal := ExpectedShares(weights, s.numPartitions)
+	var report BalanceReport
+	var squares float64
+	for i, n := range s.partitionCounts() {
+		node := s.lookupNodes[i]
+		b := NodeBalance{Node: node, Ideal: ideal[node], Actual: n, Deviation: n - ideal[node]}
+		report.Nodes = append(report.Nodes, b)
+		if d := abs(b.Deviation); d > report.MaxDeviation {
+			report.MaxDeviation = d
+		}
+		squares += float64(b.Deviation) * float64(b.Deviation)
+	}
+	if len(report.Nodes) > 0 {
+		report.RMSDeviation = math.Sqrt(squares / float64(len(report.Nodes)))
+	}
+	return report
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
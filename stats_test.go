@@ -0,0 +1,207 @@
+package maglev
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := m.String()
+	for _, want := range []string{"nodes: 3", "partitions: 101"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("String() = %q, want it to contain %q", s, want)
+		}
+	}
+	if _, err := m.Add("d"); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.String(); !strings.Contains(s, "nodes: 4") {
+		t.Errorf("String() = %q after Add, want it to contain %q", s, "nodes: 4")
+	}
+}
+
+func TestDistributionAfterChange(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c"}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Distribution()) != 3 {
+		t.Fatalf("Distribution() = %v, want 3 nodes", m.Distribution())
+	}
+	if _, err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	dist := m.Distribution()
+	if len(dist) != 2 || dist["b"]+dist["c"] != 101 {
+		t.Errorf("Distribution() = %v after Remove, want b and c owning 101 partitions", dist)
+	}
+}
+
+func TestMaxImbalance(t *testing.T) {
+	h1, h2 := DefaultHashers()
+	for _, numPartitions := range []uint64{13, 101, 1009, 10007, 65537} {
+		for _, numNodes := range []int{1, 3, 7, 13} {
+			var nodes []string
+			for i := 0; i < numNodes; i++ {
+				nodes = append(nodes, fmt.Sprintf("node-%d", i))
+			}
+			m, err := NewMaglev(nodes, numPartitions, h1, h2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := 0
+			for _, n := range m.Distribution() {
+				total += n
+			}
+			if uint64(total) != numPartitions {
+				t.Errorf("%d nodes, %d partitions: Distribution adds up to %d", numNodes, numPartitions, total)
+			}
+			if bound := 1 + float64(numNodes)/float64(numPartitions); m.MaxImbalance() > bound {
+				t.Errorf("%d nodes, %d partitions: MaxImbalance() = %.4f, want at most %.4f",
+					numNodes, numPartitions, m.MaxImbalance(), bound)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	m, err := NewWeightedMaglev(map[string]uint64{"a": 1, "b": 2, "c": 3, "d": 1}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := m.Stats()
+	if stats.Nodes != 4 || stats.Partitions != 1009 {
+		t.Errorf("Stats() = %+v, want 4 nodes and 1009 partitions", stats)
+	}
+	total, min, max := 0, int(stats.Partitions), 0
+	for _, n := range m.Distribution() {
+		total += n
+		if n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+	if uint64(total) != stats.Partitions {
+		t.Errorf("shares add up to %d, want %d", total, stats.Partitions)
+	}
+	if stats.MinShare != min || stats.MaxShare != max {
+		t.Errorf("Stats() = %+v, want MinShare %d and MaxShare %d", stats, min, max)
+	}
+	if mean := float64(stats.Partitions) / float64(stats.Nodes); stats.MeanShare != mean {
+		t.Errorf("MeanShare = %v, want %v", stats.MeanShare, mean)
+	}
+	if stats.Imbalance != float64(stats.MaxShare)/stats.MeanShare {
+		t.Errorf("Imbalance = %v, want MaxShare / MeanShare", stats.Imbalance)
+	}
+
+	empty, err := NewMaglev(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := empty.Stats(); stats != (RingStats{Partitions: 13}) {
+		t.Errorf("Stats() of an empty ring = %+v", stats)
+	}
+}
+
+func TestExpectedShares(t *testing.T) {
+	tests := []struct {
+		weights       map[string]uint64
+		numPartitions uint64
+		want          map[string]int
+	}{
+		{map[string]uint64{"a": 1, "b": 1, "c": 1}, 13, map[string]int{"a": 5, "b": 4, "c": 4}},
+		{map[string]uint64{"a": 1, "b": 2, "c": 3}, 13, map[string]int{"a": 2, "b": 4, "c": 7}},
+		{map[string]uint64{"a": 1, "b": 1 << 40}, 101, map[string]int{"a": 0, "b": 101}},
+		{map[string]uint64{"a": 0, "b": 0}, 13, map[string]int{}},
+		{map[string]uint64{"a": 1 << 63, "b": 1 << 63}, 13, map[string]int{}},
+		{nil, 13, map[string]int{}},
+	}
+	for _, tt := range tests {
+		got := ExpectedShares(tt.weights, tt.numPartitions)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("ExpectedShares(%v, %d) = %v, want %v", tt.weights, tt.numPartitions, got, tt.want)
+		}
+	}
+
+	weights := map[string]uint64{"a": 3, "b": 5, "c": 7, "d": 11, "e": 13}
+	for _, numPartitions := range []uint64{13, 101, 1009, 65537} {
+		shares := ExpectedShares(weights, numPartitions)
+		sum := 0
+		for _, share := range shares {
+			sum += share
+		}
+		if uint64(sum) != numPartitions {
+			t.Errorf("shares for %d partitions add up to %d", numPartitions, sum)
+		}
+		if numPartitions < 1009 {
+			continue
+		}
+		m, err := NewWeightedMaglev(weights, numPartitions, XXHasher{}, FNVHasher{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for node, got := range m.Distribution() {
+			if d := got - shares[node]; d < -1 || d > 1 {
+				t.Errorf("with %d partitions, node %q owns %d partitions, want %d ± 1",
+					numPartitions, node, got, shares[node])
+			}
+		}
+	}
+}
+
+func TestBalanceReport(t *testing.T) {
+	weights := map[string]uint64{"a": 1, "b": 2, "c": 3, "d": 5}
+	m, err := NewWeightedMaglev(weights, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := m.BalanceReport()
+	if len(report.Nodes) != m.Size() {
+		t.Fatalf("report has %d nodes, want %d", len(report.Nodes), m.Size())
+	}
+	dist := m.Distribution()
+	ideal, deviation, maxDeviation := 0, 0, 0
+	var squares float64
+	for i, b := range report.Nodes {
+		if b.Node != m.Nodes()[i] {
+			t.Errorf("node %d of the report is %q, want %q", i, b.Node, m.Nodes()[i])
+		}
+		if b.Actual != dist[b.Node] || b.Deviation != b.Actual-b.Ideal {
+			t.Errorf("inconsistent entry %+v, node owns %d partitions", b, dist[b.Node])
+		}
+		ideal += b.Ideal
+		deviation += b.Deviation
+		if abs(b.Deviation) > maxDeviation {
+			maxDeviation = abs(b.Deviation)
+		}
+		squares += float64(b.Deviation * b.Deviation)
+	}
+	if ideal != 1009 {
+		t.Errorf("ideal shares add up to %d, want 1009", ideal)
+	}
+	if deviation != 0 {
+		t.Errorf("deviations add up to %d, want 0", deviation)
+	}
+	if report.MaxDeviation != maxDeviation {
+		t.Errorf("MaxDeviation = %d, want %d", report.MaxDeviation, maxDeviation)
+	}
+	if want := math.Sqrt(squares / 4); math.Abs(report.RMSDeviation-want) > 1e-9 {
+		t.Errorf("RMSDeviation = %v, want %v", report.RMSDeviation, want)
+	}
+
+	empty, err := NewMaglev(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := empty.BalanceReport(); len(report.Nodes) != 0 || report.RMSDeviation != 0 {
+		t.Errorf("report of an empty Maglev = %+v", report)
+	}
+}
@@ -0,0 +1,89 @@
+package maglev
+
+// MaglevU64 is a Maglev whose nodes are identified by uint64 rather than by strings. It is
+// backed by a Maglev whose node names are the 8-byte big-endian encodings of the ids, so the
+// hashers see the raw bytes of an id rather than its decimal representation, and nodes are
+// ordered numerically.
+type MaglevU64 struct {
+	m *Maglev
+}
+
+// NewMaglevU64 initializes a MaglevU64 hasher, see NewMaglev.
+func NewMaglevU64(nodes []uint64, numPartitions uint64, h1, h2 Hasher, opts ...Option) (*MaglevU64, error) {
+	m, err := NewMaglev(u64Names(nodes), numPartitions, h1, h2, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &MaglevU64{m: m}, nil
+}
+
+// Lookup returns the node the key belongs to. ok is false if MaglevU64 has no nodes.
+func (u *MaglevU64) Lookup(key uint64) (node uint64, ok bool) {
+	s := u.m.load()
+	s.observeLookups(1)
+	if s.lookup == nil {
+		return 0, false
+	}
+	return u64ID(s.owner(uint64(s.partitionID(key)))), true
+}
+
+// Add adds new nodes, see Maglev.Add.
+func (u *MaglevU64) Add(nodes ...uint64) (int, error) {
+	return u.m.Add(u64Names(nodes)...)
+}
+
+// Remove removes nodes, see Maglev.Remove.
+func (u *MaglevU64) Remove(nodes ...uint64) (int, error) {
+	return u.m.Remove(u64Names(nodes)...)
+}
+
+// Contains returns true if MaglevU64 contains the node.
+func (u *MaglevU64) Contains(node uint64) bool {
+	return u.m.Contains(u64Name(node))
+}
+
+// Nodes returns the nodes in ascending order.
+func (u *MaglevU64) Nodes() []uint64 {
+	s := u.m.load()
+	nodes := make([]uint64, len(s.nodes))
+	for i, name := range s.nodes {
+		nodes[i] = u64ID(name)
+	}
+	return nodes
+}
+
+// Size returns the number of nodes.
+func (u *MaglevU64) Size() int {
+	return u.m.Size()
+}
+
+// Maglev returns the underlying Maglev, whose node names are the encodings of the ids.
+func (u *MaglevU64) Maglev() *Maglev {
+	return u.m
+}
+
+// u64Name returns the node name of the id, its 8-byte big-endian encoding.
+func u64Name(id uint64) string {
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(id >> (56 - 8*i))
+	}
+	return string(b[:])
+}
+
+func u64Names(ids []uint64) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = u64Name(id)
+	}
+	return names
+}
+
+// u64ID returns the id encoded in the node name by u64Name.
+func u64ID(name string) uint64 {
+	var id uint64
+	for i := 0; i < len(name); i++ {
+		id = id<<8 | uint64(name[i])
+	}
+	return id
+}
@@ -0,0 +1,47 @@
+package maglev
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMaglevU64(t *testing.T) {
+	u, err := NewMaglevU64([]uint64{300, 2, 1 << 40, 2}, 101, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Nodes(); fmt.Sprint(got) != fmt.Sprint([]uint64{2, 300, 1 << 40}) {
+		t.Errorf("Nodes() = %v, want numerically sorted ids without duplicates", got)
+	}
+	if u.Size() != 3 || !u.Contains(300) || u.Contains(3) {
+		t.Errorf("ring has nodes %v", u.Nodes())
+	}
+	for key := uint64(0); key < 1000; key++ {
+		node, ok := u.Lookup(key)
+		if !ok || u64Name(node) != u.Maglev().Lookup(key) {
+			t.Fatalf("Lookup(%d) = %d, %v, want the id of %q", key, node, ok, u.Maglev().Lookup(key))
+		}
+	}
+	if added, err := u.Add(math.MaxUint64); err != nil || added != 1 {
+		t.Fatalf("Add = %d, %v", added, err)
+	}
+	if removed, err := u.Remove(2, 300); err != nil || removed != 2 {
+		t.Fatalf("Remove = %d, %v", removed, err)
+	}
+	if got := u.Nodes(); fmt.Sprint(got) != fmt.Sprint([]uint64{1 << 40, math.MaxUint64}) {
+		t.Errorf("Nodes() = %v after Add and Remove", got)
+	}
+	if _, err := u.Remove(1<<40, math.MaxUint64); !errors.Is(err, ErrEmptyRing) {
+		t.Errorf("removing all nodes returned %v, want ErrEmptyRing", err)
+	}
+
+	empty, err := NewMaglevU64(nil, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := empty.Lookup(1); ok {
+		t.Error("Lookup on an empty ring reported a node")
+	}
+}
@@ -0,0 +1,105 @@
+package maglev
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks the internal consistency of Maglev and returns an error describing the
+// first violation found: the nodes must be sorted and unique, the permutations of every node
+// must visit every partition exactly once, and every partition of the lookup table must be
+// owned by a node of Maglev, with every node owning at least one partition unless partitions
+// are pinned to other nodes, and pinned partitions must be owned by their node. It is meant for
+// defensive checks, e.g. after restoring a Maglev with UnmarshalBinary.
+func (m *Maglev) Validate() error {
+	return m.load().validate()
+}
+
+func (s *state) validate() error {
+	for i, node := range s.nodes {
+		if i > 0 && !s.nodeLess(s.nodes[i-1], node) {
+			return fmt.Errorf("nodes %q and %q are not sorted or not unique", s.nodes[i-1], node)
+		}
+		if !s.contains(node) {
+			return fmt.Errorf("node %q is missing from the membership set", node)
+		}
+	}
+	if len(s.members) != len(s.nodes) {
+		return fmt.Errorf("membership set has %d nodes, want %d", len(s.members), len(s.nodes))
+	}
+	for node := range s.draining {
+		if !s.contains(node) {
+			return fmt.Errorf("draining node %q is not in Maglev", node)
+		}
+	}
+	if uint64(len(s.nodes)) > s.numPartitions {
+		return tooManyNodes(len(s.nodes), s.numPartitions)
+	}
+
+	// permutations are generated lazily after UnmarshalBinary
+	if s.permutations != nil {
+		if want := len(s.nodes) * s.numReplicas(); len(s.permutations) != want {
+			return fmt.Errorf("found %d permutations, want %d", len(s.permutations), want)
+		}
+		seen := make([]bool, s.numPartitions)
+		for i, permutation := range s.permutations {
+			node := s.nodes[i/s.numReplicas()]
+			if uint64(len(permutation)) != s.numPartitions {
+				return fmt.Errorf("permutation of node %q has length %d, want %d",
+					node, len(permutation), s.numPartitions)
+			}
+			// populateLookup would not terminate if a permutation missed a partition
+			for j := range seen {
+				seen[j] = false
+			}
+			for _, partition := range permutation {
+				if partition >= s.numPartitions || seen[partition] {
+					return fmt.Errorf("permutation of node %q is not a permutation of the partitions", node)
+				}
+				seen[partition] = true
+			}
+		}
+	}
+
+	// the lookup table of a Maglev with deferred rebuilds refers to the nodes it was
+	// populated from, which may differ from the current ones
+	nodes := s.nodes
+	if s.stale {
+		nodes = s.lookupNodes
+	}
+	if len(nodes) == 0 {
+		if s.lookup != nil {
+			return errors.New("lookup table is populated without nodes")
+		}
+		return nil
+	}
+	if uint64(len(s.lookup)) != s.numPartitions {
+		return fmt.Errorf("lookup table has %d partitions, want %d", len(s.lookup), s.numPartitions)
+	}
+	if len(s.lookupNodes) != len(nodes) {
+		return fmt.Errorf("lookup table refers to %d nodes, want %d", len(s.lookupNodes), len(nodes))
+	}
+	counts := make([]int, len(nodes))
+	for partition, idx := range s.lookup {
+		if idx < 0 || int(idx) >= len(nodes) {
+			return fmt.Errorf("partition %d has no owner", partition)
+		}
+		if s.lookupNodes[idx] != nodes[idx] {
+			return fmt.Errorf("partition %d is owned by unknown node %q", partition, s.lookupNodes[idx])
+		}
+		counts[idx]++
+	}
+	if !s.stale {
+		for partition, node := range s.pins {
+			if owner := s.owner(partition); owner != node {
+				return fmt.Errorf("partition %d is pinned to %q, but owned by %q", partition, node, owner)
+			}
+		}
+	}
+	for i, n := range counts {
+		if n == 0 && len(s.pins) == 0 {
+			return fmt.Errorf("node %q owns no partitions", nodes[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package maglev
+
+import "testing"
+
+// corrupt returns a new Maglev whose state has been modified by fn.
+func corrupt(t *testing.T, fn func(s *state)) *Maglev {
+	t.Helper()
+	m, err := NewMaglev([]string{"a", "b", "c"}, 13, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := *m.load()
+	s.nodes = append([]string(nil), s.nodes...)
+	s.lookup = append([]int32(nil), s.lookup...)
+	s.permutations = append([][]uint64(nil), s.permutations...)
+	s.permutations[0] = append([]uint64(nil), s.permutations[0]...)
+	fn(&s)
+	m.store(&s)
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	if err := corrupt(t, func(*state) {}).Validate(); err != nil {
+		t.Fatalf("Validate of an intact ring: %v", err)
+	}
+	tests := []struct {
+		name string
+		fn   func(s *state)
+	}{
+		{"unsorted nodes", func(s *state) { s.nodes[0], s.nodes[1] = s.nodes[1], s.nodes[0] }},
+		{"duplicate nodes", func(s *state) { s.nodes[1] = s.nodes[0] }},
+		{"missing member", func(s *state) { s.members = membersOf(s.nodes[1:]) }},
+		{"unknown draining node", func(s *state) { s.draining = map[string]struct{}{"x": {}} }},
+		{"too many nodes", func(s *state) { s.numPartitions = 2 }},
+		{"missing permutation", func(s *state) { s.permutations = s.permutations[1:] }},
+		{"repeated partition", func(s *state) { s.permutations[0][1] = s.permutations[0][0] }},
+		{"short lookup table", func(s *state) { s.lookup = s.lookup[1:] }},
+		{"partition without owner", func(s *state) { s.lookup[0] = 3 }},
+		{"node without partitions", func(s *state) {
+			for i := range s.lookup {
+				s.lookup[i] = 0
+			}
+		}},
+		{"moved pin", func(s *state) {
+			s.pins = map[uint64]string{0: s.nodes[(s.lookup[0]+1)%3]}
+		}},
+	}
+	for _, tt := range tests {
+		if err := corrupt(t, tt.fn).Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded", tt.name)
+		}
+	}
+}
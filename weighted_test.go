@@ -0,0 +1,186 @@
+package maglev
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestWeightedShares(t *testing.T) {
+	tests := []struct {
+		weights       map[string]uint64
+		numPartitions uint64
+	}{
+		{map[string]uint64{"a": 1, "b": 1, "c": 1}, 101},
+		{map[string]uint64{"a": 100, "b": 100, "c": 100}, 101},
+		{map[string]uint64{"a": 100, "b": 100, "c": 100}, 1009},
+		{map[string]uint64{"a": 1, "b": 2, "c": 3}, 1009},
+		{map[string]uint64{"a": 1, "b": 2, "c": 3}, 65537},
+		{map[string]uint64{"a": 1000, "b": 2000, "c": 3000}, 1009},
+		{map[string]uint64{"a": 1, "b": 7, "c": 3, "d": 40, "e": 2}, 10007},
+		{map[string]uint64{"a": 1 << 55, "b": 1 << 54, "c": 1 << 53}, 101},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.weights, tt.numPartitions), func(t *testing.T) {
+			m, err := NewWeightedMaglev(tt.weights, tt.numPartitions, XXHasher{}, FNVHasher{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			want := ExpectedShares(tt.weights, tt.numPartitions)
+			for node, got := range m.Distribution() {
+				if d := got - want[node]; d < -1 || d > 1 {
+					t.Errorf("node %q owns %d partitions, want %d ± 1", node, got, want[node])
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedScaleInvariant(t *testing.T) {
+	a, _ := NewWeightedMaglev(map[string]uint64{"a": 1, "b": 2, "c": 3}, 1009, XXHasher{}, FNVHasher{})
+	b, _ := NewWeightedMaglev(map[string]uint64{"a": 10, "b": 20, "c": 30}, 1009, XXHasher{}, FNVHasher{})
+	if !a.Equal(b) {
+		t.Error("weights with the same ratios produced different lookup tables")
+	}
+}
+
+func TestWeightedOverflow(t *testing.T) {
+	weights := map[string]uint64{"a": 1 << 63, "b": 1 << 63}
+	if _, err := NewWeightedMaglev(weights, 13, XXHasher{}, FNVHasher{}, WithMaxLoadFactor(1.25)); err == nil {
+		t.Error("weights adding up to more than a uint64 were accepted")
+	}
+
+	m, err := NewWeightedMaglev(map[string]uint64{"a": 1}, 13, XXHasher{}, FNVHasher{}, WithMaxLoadFactor(1.25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddWeighted(1<<63, "b"); err == nil {
+		t.Error("AddWeighted accepted a weight too large for the number of partitions")
+	}
+	if err := m.Reweight("a", 1<<63); err == nil {
+		t.Error("Reweight accepted a weight too large for the number of partitions")
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWeightedLookupN(t *testing.T) {
+	m, err := NewWeightedMaglev(map[string]uint64{"a": 8, "b": 1, "c": 1, "d": 1}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mean position of every node among the backups of the keys owned by other nodes
+	sums, counts := make(map[string]int), make(map[string]int)
+	for key := uint64(0); key < 10000; key++ {
+		for i, node := range m.LookupN(key, 4)[1:] {
+			sums[node] += i
+			counts[node]++
+		}
+	}
+	mean := func(node string) float64 { return float64(sums[node]) / float64(counts[node]) }
+	for _, node := range []string{"b", "c", "d"} {
+		if mean("a") >= mean(node) {
+			t.Errorf("heavy node a is at mean backup position %.2f, not earlier than %q at %.2f",
+				mean("a"), node, mean(node))
+		}
+	}
+}
+
+func TestMaxLoadFactor(t *testing.T) {
+	const c = 1.25
+	checkCaps := func(m *Maglev, weights map[string]uint64) {
+		t.Helper()
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		var total uint64
+		for _, node := range m.Nodes() {
+			total += weights[node]
+		}
+		for node, got := range m.Distribution() {
+			limit := int(math.Ceil(c * float64(m.Partitions()) * float64(weights[node]) / float64(total)))
+			if got > limit {
+				t.Errorf("node %q owns %d partitions, more than its cap of %d", node, got, limit)
+			}
+		}
+	}
+
+	weights := map[string]uint64{"a": 1, "b": 2, "c": 3, "d": 1, "e": 1}
+	m, err := NewWeightedMaglev(weights, 101, XXHasher{}, FNVHasher{}, WithMaxLoadFactor(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCaps(m, weights)
+
+	// pinned partitions count towards the cap of their node
+	pinned := 0
+	for partition := 0; pinned < 10; partition++ {
+		if owner, _ := m.PartitionOwner(partition); owner != "a" {
+			if err := m.Pin(partition, "a"); err != nil {
+				t.Fatal(err)
+			}
+			pinned++
+		}
+	}
+	checkCaps(m, weights)
+
+	weights["f"] = 2
+	if _, err := m.AddWeighted(2, "f"); err != nil {
+		t.Fatal(err)
+	}
+	checkCaps(m, weights)
+	if _, err := m.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	checkCaps(m, weights)
+
+	if _, err := NewMaglev([]string{"a"}, 13, XXHasher{}, FNVHasher{}, WithMaxLoadFactor(0.5)); err == nil {
+		t.Error("a load factor below 1 was accepted")
+	}
+}
+
+func TestReweight(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, 1009, XXHasher{}, FNVHasher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Clone()
+	if err := m.Reweight("a", 4); err != nil {
+		t.Fatal(err)
+	}
+	want := ExpectedShares(map[string]uint64{"a": 4, "b": 1, "c": 1, "d": 1}, 1009)
+	dist := m.Distribution()
+	for node, got := range dist {
+		if d := got - want[node]; d < -1 || d > 1 {
+			t.Errorf("after Reweight, node %q owns %d partitions, want %d ± 1", node, got, want[node])
+		}
+	}
+	// the other nodes share the loss evenly, as checked above, and at least the gain of a moves
+	minimum := dist["a"] - before.Distribution()["a"]
+	moved, _, err := Disruption(before, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved > minimum*11/10 {
+		t.Errorf("Reweight moved %d partitions, want close to the minimum of %d", moved, minimum)
+	}
+
+	if err := m.Reweight("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(before) {
+		t.Error("restoring the weight did not restore the lookup table")
+	}
+
+	if err := m.Reweight("e", 2); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Reweight of an unknown node = %v, want ErrNodeNotFound", err)
+	}
+	if err := m.Reweight("a", 0); err == nil {
+		t.Error("Reweight accepted weight 0")
+	}
+}